@@ -0,0 +1,159 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	stateOk          = "ok"
+	stateUnmounted   = "unmounted"
+	stateRootMissing = "mount root unavailable"
+	stateDisconnect  = "not connected"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// checkParent verifies that the directory the plugin mount root lives in
+// is present and writable, so a vanished Docker data dir is reported as
+// such instead of as a failed mount.
+func checkParent(mountpoint string) error {
+	dir := filepath.Dir(filepath.Dir(mountpoint))
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("mountpoint parent '%s' unavailable: %s", dir, err.Error())
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("mountpoint parent '%s' is not a directory", dir)
+	}
+	if err := syscall.Access(dir, 2); err != nil {
+		return fmt.Errorf("mountpoint parent '%s' not writable: %s", dir, err.Error())
+	}
+	return nil
+}
+
+// checkSymlink refuses a mountpoint that is a symlink. Mounting on its
+// target would hide the mount from the checks and the unmount, which look
+// for the mountpoint path in mountinfo.
+func (d *ofsDriver) checkSymlink(dir string) error {
+	if fi, err := os.Lstat(dir); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("mountpoint '%s' is a symlink", dir)
+	}
+	return nil
+}
+
+// volumeState returns the state of the mount of v. The stat of the
+// mountpoint is bounded, since it blocks on a wedged FUSE mount while v is
+// locked.
+func volumeState(v *ofsVolume) string {
+	if !v.mounted {
+		return stateUnmounted
+	}
+	if _, err := os.Stat(filepath.Dir(filepath.Dir(v.volume.Mountpoint))); err != nil {
+		return stateRootMissing
+	}
+	if err := statWithin(v.volume.Mountpoint, staleTimeout); err != nil {
+		if err == errNotConnected {
+			return stateDisconnect
+		}
+		return err.Error()
+	}
+	return stateOk
+}
+
+func (d *ofsDriver) health(w http.ResponseWriter, r *http.Request) {
+	status := stateOk
+	vs := make(map[string]string)
+	for _, v := range d.snapshot() {
+		v.Lock()
+		name, state := v.volume.Name, volumeState(v)
+		v.Unlock()
+		vs[name] = state
+		if state != stateOk && state != stateUnmounted {
+			status = "degraded"
+		}
+	}
+	res := map[string]interface{}{"status": status, "volumes": vs}
+	if d.config.metricsAddr != "" {
+		res["metrics"] = metrics.getListenState()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// volumeAction serves /volumes/<name>/<action>.
+func (d *ofsDriver) volumeAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/volumes/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := parts[0], parts[1]
+	switch {
+	case action == "sync" && r.Method == http.MethodPost:
+		if err := d.syncVolume(name); err != nil {
+			writeJSON(w, errorStatus(w, err, http.StatusInternalServerError), map[string]string{"Err": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{})
+	case action == "remove-plan" && r.Method == http.MethodGet:
+		v, err := d.lookup(name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"Err": err.Error()})
+			return
+		}
+		plan := map[string]interface{}{"removable": len(v.use) == 0, "users": len(v.use), "mounted": v.mounted}
+		if users := d.users(v); len(users) != 0 {
+			plan["blocked_by"] = users
+		}
+		if v.mounted {
+			plan["unmount_policy"] = d.config.removeUnmount
+		}
+		v.Unlock()
+		writeJSON(w, http.StatusOK, plan)
+	case action == "env-resolution" && r.Method == http.MethodGet:
+		d.envResolution(w, name)
+	case action == "logs" && r.Method == http.MethodGet:
+		d.logs(w, r, name)
+	case action == "warm" && r.Method == http.MethodPost:
+		if status, err := d.warm(name, r); err != nil {
+			writeJSON(w, errorStatus(w, err, status), map[string]string{"Err": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{})
+	case action == "reset" && r.Method == http.MethodPost:
+		v, err := d.lookup(name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"Err": err.Error()})
+			return
+		}
+		d.resetBreaker(v)
+		v.Unlock()
+		log.Printf("Reset crash breaker of ObjectiveFS Volume '%s'", name)
+		writeJSON(w, http.StatusOK, map[string]string{})
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestCheckParent(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkParent(filepath.Join(dir, "objectivefs", "vol")); err != nil {
+		t.Errorf("checkParent with existing parent: %v", err)
+	}
+	if err := checkParent(filepath.Join(dir, "gone", "objectivefs", "vol")); err == nil {
+		t.Error("checkParent with missing parent succeeded")
+	}
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkParent(filepath.Join(file, "objectivefs", "vol")); err == nil {
+		t.Error("checkParent with a file as parent succeeded")
+	}
+}
+
+func TestVolumeState(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	if got := volumeState(v); got != stateUnmounted {
+		t.Errorf("unmounted volume: state %q, want %q", got, stateUnmounted)
+	}
+	v.mounted = true
+	if got := volumeState(v); got == stateOk || got == stateRootMissing {
+		t.Errorf("missing mountpoint: state %q", got)
+	}
+	if err := d.makeMountpoint(v.volume.Mountpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got := volumeState(v); got != stateOk {
+		t.Errorf("present mountpoint: state %q, want %q", got, stateOk)
+	}
+	v.volume.Mountpoint = filepath.Join(t.TempDir(), "gone", "objectivefs", "vol")
+	if got := volumeState(v); got != stateRootMissing {
+		t.Errorf("missing root: state %q, want %q", got, stateRootMissing)
+	}
+}
+
+func TestMountpointSymlink(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	mp := d.volumes["vol"].volume.Mountpoint
+	target := filepath.Join(mountRoot, "target")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkSymlink(target); err != nil {
+		t.Errorf("checkSymlink of a directory: %v", err)
+	}
+	if err := d.checkSymlink(mp); err != nil {
+		t.Errorf("checkSymlink of a missing mountpoint: %v", err)
+	}
+	if err := os.Symlink(target, mp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err == nil || !strings.Contains(err.Error(), "is a symlink") {
+		t.Errorf("Mount on a symlink: %v", err)
+	}
+	if n := f.helperCalls(); n != 0 {
+		t.Errorf("mount helper ran %d times on a symlink", n)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	long := strings.Repeat("a", 64)
+	tests := []struct {
+		show string
+		want []string
+	}{
+		{"full", []string{long, "b"}},
+		{"short", []string{long[:12], "b"}},
+		{"none", nil},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.showUsers = tt.show
+		v := testVolume(t, map[string]string{"fs": "myfs"})
+		v.use["b"], v.use[long] = true, true
+		if got := d.users(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: users %q, want %q", tt.show, got, tt.want)
+		}
+		d.volumes["vol"] = v
+		err := d.Remove(&volume.RemoveRequest{Name: "vol"})
+		if err == nil || !strings.Contains(err.Error(), "currently in use (2 unique)") {
+			t.Errorf("%s: Remove of a volume in use: %v", tt.show, err)
+		} else if tt.want != nil && !strings.HasSuffix(err.Error(), ": "+strings.Join(tt.want, ", ")) {
+			t.Errorf("%s: Remove error %q does not list the users", tt.show, err)
+		} else if tt.want == nil && !strings.HasSuffix(err.Error(), "(2 unique)") {
+			t.Errorf("%s: Remove error %q lists the users", tt.show, err)
+		}
+	}
+}
+
+func TestRemovePlan(t *testing.T) {
+	d := testDriver(t)
+	d.config.showUsers, d.config.removeUnmount = "full", umountLazy
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	d.volumes["vol"] = v
+	plan := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		d.volumeAction(w, httptest.NewRequest("GET", "/volumes/vol/remove-plan", nil))
+		var p map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	if p := plan(); p["removable"] != true || p["mounted"] != false || p["blocked_by"] != nil || p["unmount_policy"] != nil {
+		t.Errorf("plan of an unused volume %v", p)
+	}
+	v.use["c1"], v.mounted = true, true
+	if p := plan(); p["removable"] != false || p["users"] != 1.0 || !reflect.DeepEqual(p["blocked_by"], []interface{}{"c1"}) || p["unmount_policy"] != umountLazy {
+		t.Errorf("plan of a volume in use %v", p)
+	}
+	w := httptest.NewRecorder()
+	d.volumeAction(w, httptest.NewRequest("GET", "/volumes/other/remove-plan", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("plan of an unknown volume: %d", w.Code)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var fileNrPath = "/proc/sys/fs/file-nr"
+
+// hostReadings are the host resources the mount admission check uses.
+type hostReadings struct {
+	MemAvailable uint64 `json:"memory_available"`
+	FDsAvailable uint64 `json:"fds_available"`
+	FuseMounts   int    `json:"fuse_mounts"`
+}
+
+// parseFileNr returns the number of file handles that can still be
+// allocated from a /proc/sys/fs/file-nr.
+func parseFileNr(data []byte) (uint64, error) {
+	f := strings.Fields(string(data))
+	if len(f) != 3 {
+		return 0, fmt.Errorf("malformed file-nr")
+	}
+	used, err1 := strconv.ParseUint(f[0], 10, 64)
+	max, err2 := strconv.ParseUint(f[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("malformed file-nr")
+	}
+	if used > max {
+		return 0, nil
+	}
+	return max - used, nil
+}
+
+func readHost() (hostReadings, error) {
+	var h hostReadings
+	f, err := os.Open(meminfoPath)
+	if err != nil {
+		return h, err
+	}
+	info, err := parseMeminfo(f)
+	f.Close()
+	if err != nil {
+		return h, err
+	}
+	h.MemAvailable = info["MemAvailable"]
+	b, err := ioutil.ReadFile(fileNrPath)
+	if err != nil {
+		return h, err
+	}
+	if h.FDsAvailable, err = parseFileNr(b); err != nil {
+		return h, err
+	}
+	mounts, err := readMountinfo()
+	if err != nil {
+		return h, err
+	}
+	for _, m := range mounts {
+		if expectedType(m.fstype, false) {
+			h.FuseMounts++
+		}
+	}
+	return h, nil
+}
+
+// admit tells why the host readings h do not allow one more FUSE mount,
+// or returns an empty string if they do.
+func (c ofsConfig) admit(h hostReadings) string {
+	switch {
+	case c.admitMinMemory > 0 && h.MemAvailable < c.admitMinMemory:
+		return fmt.Sprintf("low memory, %d bytes available", h.MemAvailable)
+	case c.admitMinFDs > 0 && h.FDsAvailable < uint64(c.admitMinFDs):
+		return fmt.Sprintf("low on file handles, %d available", h.FDsAvailable)
+	case c.admitMaxFuse > 0 && h.FuseMounts >= c.admitMaxFuse:
+		return fmt.Sprintf("%d FUSE mounts", h.FuseMounts)
+	}
+	return ""
+}
+
+// checkAdmission refuses a new mount while the host is short on resources,
+// or its resources cannot be read, with an error that tells the caller to
+// retry.
+func (d *ofsDriver) checkAdmission() error {
+	if d.config.admitMinMemory == 0 && d.config.admitMinFDs == 0 && d.config.admitMaxFuse == 0 {
+		return nil
+	}
+	h, err := readHost()
+	if err != nil {
+		log.Printf("Warning: cannot read host resources for the mount admission check: %s", err.Error())
+		metrics.inc("objectivefs_admission_refused_total")
+		return &notReadyError{"host resources unknown", 30 * time.Second}
+	}
+	if reason := d.config.admit(h); reason != "" {
+		metrics.inc("objectivefs_admission_refused_total")
+		return &notReadyError{"host " + reason, 30 * time.Second}
+	}
+	return nil
+}
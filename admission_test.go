@@ -0,0 +1,99 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFileNr(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+		ok   bool
+	}{
+		{"1024\t0\t9223372036854775807\n", 9223372036854774783, true},
+		{"500 0 1000", 500, true},
+		{"1000 0 1000", 0, true},
+		{"1200 0 1000", 0, true},
+		{"500 0", 0, false},
+		{"x 0 1000", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseFileNr([]byte(tt.data))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseFileNr(%q) = %d, %v", tt.data, got, err)
+		}
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	c := ofsConfig{admitMinMemory: 1 << 30, admitMinFDs: 1000, admitMaxFuse: 10}
+	tests := []struct {
+		c    ofsConfig
+		h    hostReadings
+		want string
+	}{
+		{c, hostReadings{2 << 30, 5000, 3}, ""},
+		{c, hostReadings{1 << 29, 5000, 3}, "low memory"},
+		{c, hostReadings{2 << 30, 999, 3}, "low on file handles"},
+		{c, hostReadings{2 << 30, 1000, 10}, "10 FUSE mounts"},
+		{c, hostReadings{2 << 30, 1000, 9}, ""},
+		{ofsConfig{}, hostReadings{0, 0, 100}, ""},
+	}
+	for _, tt := range tests {
+		got := tt.c.admit(tt.h)
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("admit(%+v) = %q, want %q", tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAdmission(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	dir := t.TempDir()
+	oldMem, oldNr := meminfoPath, fileNrPath
+	meminfoPath, fileNrPath = filepath.Join(dir, "meminfo"), filepath.Join(dir, "file-nr")
+	t.Cleanup(func() { meminfoPath, fileNrPath = oldMem, oldNr })
+	ioutil.WriteFile(meminfoPath, []byte("MemTotal: 8388608 kB\nMemAvailable: 4194304 kB\n"), 0644)
+	ioutil.WriteFile(fileNrPath, []byte("100 0 1000\n"), 0644)
+	if err := d.checkAdmission(); err != nil {
+		t.Errorf("admission without thresholds: %v", err)
+	}
+	d.config.admitMinFDs = 2000
+	err := d.checkAdmission()
+	if _, ok := err.(*notReadyError); !ok || !strings.Contains(err.Error(), "low on file handles, 900 available") {
+		t.Errorf("admission short of file handles: %v", err)
+	}
+	d.config.admitMinFDs, d.config.admitMaxFuse = 0, 1
+	if err := d.checkAdmission(); err != nil {
+		t.Errorf("admission with no FUSE mount: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(f.dir, "mountinfo"), []byte("1 1 0:1 / /mnt rw - fuse.objectivefs objectivefs rw\n"), 0644)
+	if err := d.checkAdmission(); err == nil {
+		t.Errorf("admission past the FUSE mount limit")
+	}
+	os.Remove(fileNrPath)
+	d.config.admitMaxFuse = 5
+	err = d.checkAdmission()
+	if _, ok := err.(*notReadyError); !ok || !strings.Contains(err.Error(), "host resources unknown") {
+		t.Errorf("admission with unreadable host resources: %v", err)
+	}
+}
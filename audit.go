@@ -0,0 +1,63 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ofsAudit appends one JSON object per volume lifecycle event to the
+// audit log, when one is configured.
+type ofsAudit struct {
+	sync.Mutex
+	f *os.File
+}
+
+var audit = &ofsAudit{}
+
+func (a *ofsAudit) open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	a.f = f
+	return nil
+}
+
+func (a *ofsAudit) record(event string, v *ofsVolume, kv ...string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.f == nil {
+		return
+	}
+	e := map[string]string{"time": time.Now().Format(time.RFC3339Nano), "event": event, "volume": v.volume.Name, "fs": v.fs}
+	if v.keyVersion != "" {
+		e["key_version"] = v.keyVersion
+	}
+	for key, val := range v.tags {
+		e["tag."+key] = val
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e[kv[i]] = kv[i+1]
+	}
+	b, _ := json.Marshal(e)
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		log.Printf("Audit log write failed: %s", err.Error())
+	}
+}
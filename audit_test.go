@@ -0,0 +1,64 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyVersion(t *testing.T) {
+	for val, ok := range map[string]bool{"2024-01": true, "v1.2_rc": true, "": false, "a b": false, strings.Repeat("x", 65): false} {
+		_, err := newVolume("vol", map[string]string{"fs": "myfs", "key_version": val}, "")
+		if (err == nil) != ok {
+			t.Errorf("key_version %q: %v, want ok %v", val, err, ok)
+		}
+	}
+}
+
+func TestAuditRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := &ofsAudit{}
+	a.record("create", testVolume(t, map[string]string{"fs": "myfs"}))
+	if err := a.open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer a.f.Close()
+	a.record("create", testVolume(t, map[string]string{"fs": "myfs", "key_version": "k2"}))
+	a.record("mount", testVolume(t, map[string]string{"fs": "myfs", "cost_tag": "cc-1"}), "users", "1")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2:\n%s", len(lines), b)
+	}
+	var e map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["event"] != "create" || e["volume"] != "vol" || e["fs"] != "myfs" || e["key_version"] != "k2" || e["time"] == "" {
+		t.Errorf("create entry %v", e)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["event"] != "mount" || e["users"] != "1" || e["tag.cost"] != "cc-1" {
+		t.Errorf("mount entry %v", e)
+	}
+}
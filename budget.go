@@ -0,0 +1,63 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// expired returns how many of the oldest mount failures are older than
+// window.
+func expired(failures []time.Time, window time.Duration, now time.Time) int {
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) >= window {
+		i++
+	}
+	return i
+}
+
+// checkBudget fails fast once a volume has used up its mount attempts for
+// the current window, until the oldest failure ages out of it.
+func (d *ofsDriver) checkBudget(v *ofsVolume) error {
+	if d.config.mountBudget == 0 {
+		return nil
+	}
+	now := time.Now()
+	v.failures = v.failures[expired(v.failures, d.config.budgetWindow, now):]
+	if len(v.failures) < d.config.mountBudget {
+		return nil
+	}
+	metrics.inc("objectivefs_mount_budget_exhausted_total", "volume", v.volume.Name)
+	retry := v.failures[0].Add(d.config.budgetWindow).Sub(now).Round(time.Second)
+	return fmt.Errorf("volume '%s' failed to mount %d times in %s, not retrying for %s", v.volume.Name, len(v.failures), d.config.budgetWindow, retry)
+}
+
+// mountFailed records a failed mount of v. Failures are only kept with a
+// budget, and never beyond its window.
+func (d *ofsDriver) mountFailed(v *ofsVolume, now time.Time) {
+	if d.config.mountBudget == 0 {
+		return
+	}
+	v.failures = append(v.failures[expired(v.failures, d.config.budgetWindow, now):], now)
+}
+
+func (d *ofsDriver) budgetStatus(v *ofsVolume, s map[string]interface{}) {
+	if d.config.mountBudget == 0 {
+		return
+	}
+	n := len(v.failures) - expired(v.failures, d.config.budgetWindow, time.Now())
+	s["mount_failures"] = n
+	s["mount_budget_exhausted"] = n >= d.config.mountBudget
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	failures := []time.Time{now.Add(-20 * time.Minute), now.Add(-10 * time.Minute), now.Add(-time.Minute), now}
+	tests := []struct {
+		window time.Duration
+		want   int
+	}{
+		{time.Hour, 0},
+		{15 * time.Minute, 1},
+		{10 * time.Minute, 2},
+		{time.Second, 3},
+		{0, 4},
+	}
+	for _, tt := range tests {
+		if got := expired(failures, tt.window, now); got != tt.want {
+			t.Errorf("expired(window %s) = %d, want %d", tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestMountBudget(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		d.mountFailed(v, now)
+	}
+	if len(v.failures) != 0 {
+		t.Errorf("%d failures kept without a budget", len(v.failures))
+	}
+	d.config.mountBudget, d.config.budgetWindow = 2, 10*time.Minute
+	d.mountFailed(v, now.Add(-20*time.Minute))
+	d.mountFailed(v, now.Add(-time.Minute))
+	if err := d.checkBudget(v); err != nil {
+		t.Errorf("budget exhausted by one failure in the window: %v", err)
+	}
+	d.mountFailed(v, now)
+	if len(v.failures) != 2 {
+		t.Errorf("%d failures kept, want the 2 within the window", len(v.failures))
+	}
+	if err := d.checkBudget(v); err == nil {
+		t.Error("budget not exhausted by 2 failures in the window")
+	}
+	s := make(map[string]interface{})
+	d.budgetStatus(v, s)
+	if s["mount_failures"] != 2 || s["mount_budget_exhausted"] != true {
+		t.Errorf("budget status %v", s)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// parseSize parses an ObjectiveFS size such as 512M or 20G. Percentages
+// are relative to something the driver cannot see and are not accepted.
+func parseSize(val string) (uint64, error) {
+	mult := uint64(1)
+	if n := len(val); n > 0 {
+		if i := strings.IndexByte("KMGTP", val[n-1]&^0x20); i >= 0 {
+			mult = 1 << (10 * uint(i+1))
+			val = val[:n-1]
+		}
+	}
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", val)
+	}
+	return n * mult, nil
+}
+
+// cacheDir returns the directory to use as disk cache for dev, which is
+// either a mounted block device or the mount point of a filesystem
+// dedicated to the cache.
+func cacheDir(dev string, mounts []mountEntry) (string, error) {
+	fi, err := os.Stat(dev)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeDevice != 0 && fi.Mode()&os.ModeCharDevice == 0 {
+		real, err := filepath.EvalSymlinks(dev)
+		if err != nil {
+			return "", err
+		}
+		for _, m := range mounts {
+			if m.source == dev || m.source == real {
+				return m.dir, nil
+			}
+		}
+		return "", fmt.Errorf("block device '%s' is not mounted", dev)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("'%s' is neither a block device nor a directory", dev)
+	}
+	for _, m := range mounts {
+		if m.dir == dev {
+			return dev, nil
+		}
+	}
+	return "", fmt.Errorf("'%s' is not the mount point of a filesystem", dev)
+}
+
+func setCacheDevice(v *ofsVolume) error {
+	if _, ok := envValue(v, "DISKCACHE_PATH"); ok {
+		return fmt.Errorf("cannot be combined with DISKCACHE_PATH")
+	}
+	mounts, err := readMountinfo()
+	if err != nil {
+		return err
+	}
+	dir, err := cacheDir(v.cacheDevice, mounts)
+	if err != nil {
+		return err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return err
+	}
+	if size, ok := envValue(v, "DISKCACHE_SIZE"); ok {
+		want, err := parseSize(strings.SplitN(size, ":", 2)[0])
+		if err == nil && fs.Bavail*uint64(fs.Bsize) < want {
+			return fmt.Errorf("'%s' has %d bytes available, DISKCACHE_SIZE needs %d", dir, fs.Bavail*uint64(fs.Bsize), want)
+		}
+	}
+	var root, cache syscall.Stat_t
+	if syscall.Stat("/", &root) == nil && syscall.Stat(dir, &cache) == nil && root.Dev == cache.Dev {
+		log.Printf("Warning: cache device '%s' of ObjectiveFS Volume '%s' is on the root filesystem", v.cacheDevice, v.volume.Name)
+	}
+	v.cacheDir = dir
+	setEnv(v, "DISKCACHE_PATH", dir, "cache_device")
+	return nil
+}
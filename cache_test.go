@@ -0,0 +1,88 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		val  string
+		want uint64
+		ok   bool
+	}{
+		{"512", 512, true},
+		{"4K", 4 << 10, true},
+		{"512M", 512 << 20, true},
+		{"20g", 20 << 30, true},
+		{"1T", 1 << 40, true},
+		{"2P", 2 << 50, true},
+		{"", 0, false},
+		{"M", 0, false},
+		{"20%", 0, false},
+		{"-1G", 0, false},
+		{"1.5G", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.val)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, ok %v", tt.val, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCacheDevice(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	plain := filepath.Join(dir, "plain")
+	file := filepath.Join(dir, "file")
+	for _, d := range []string{cache, plain} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setMountinfo(t, "1 1 8:2 / "+cache+" rw - ext4 /dev/sdb1 rw\n")
+	tests := []struct {
+		opts map[string]string
+		ok   bool
+	}{
+		{map[string]string{"cache_device": cache}, true},
+		{map[string]string{"cache_device": plain}, false},
+		{map[string]string{"cache_device": file}, false},
+		{map[string]string{"cache_device": filepath.Join(dir, "gone")}, false},
+		{map[string]string{"cache_device": "cache"}, false},
+		{map[string]string{"cache_device": cache, "DISKCACHE_PATH": "/var/cache"}, false},
+		{map[string]string{"cache_device": cache, "DISKCACHE_SIZE": "1P"}, false},
+	}
+	for _, tt := range tests {
+		tt.opts["fs"] = "myfs"
+		v, err := newVolume("vol", tt.opts, "")
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: %v, want ok %v", tt.opts, err, tt.ok)
+			continue
+		}
+		if err == nil {
+			if path, _ := envValue(v, "DISKCACHE_PATH"); path != cache || v.cacheDir != cache {
+				t.Errorf("%v: DISKCACHE_PATH %q, cache dir %q, want %q", tt.opts, path, v.cacheDir, cache)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// defaultCachePath is where ObjectiveFS keeps its disk cache without
+// DISKCACHE_PATH.
+const defaultCachePath = "/var/cache/objectivefs"
+
+type cacheClaim struct {
+	path string
+	size uint64
+}
+
+// diskCache returns the disk cache v uses, if it has one.
+func diskCache(v *ofsVolume) (cacheClaim, bool) {
+	val, ok := envValue(v, "DISKCACHE_SIZE")
+	if !ok {
+		return cacheClaim{}, false
+	}
+	size, err := parseSize(strings.SplitN(val, ":", 2)[0])
+	if err != nil {
+		return cacheClaim{}, false
+	}
+	path, ok := envValue(v, "DISKCACHE_PATH")
+	if !ok {
+		path = defaultCachePath
+	}
+	return cacheClaim{path, size}, true
+}
+
+// cacheAccount keeps the disk caches of the mounted volumes. Mounts that
+// share a cache directory share one cache, as large as the largest size
+// any of them asks for.
+type cacheAccount struct {
+	sync.Mutex
+	claims map[string]cacheClaim
+}
+
+var caches = &cacheAccount{claims: make(map[string]cacheClaim)}
+
+func (a *cacheAccount) set(v *ofsVolume) {
+	if c, ok := diskCache(v); ok {
+		a.Lock()
+		a.claims[v.volume.Name] = c
+		a.Unlock()
+	}
+}
+
+func (a *cacheAccount) unset(name string) {
+	a.Lock()
+	delete(a.claims, name)
+	a.Unlock()
+}
+
+// total returns the bytes claimed by all caches, replacing the claim of
+// volume name by extra.
+func (a *cacheAccount) total(name string, extra *cacheClaim) uint64 {
+	a.Lock()
+	defer a.Unlock()
+	return a.totalLocked(name, extra)
+}
+
+// reserve claims cache c for volume name, unless all caches would then
+// take more than limit, and returns the bytes they take with it. A limit
+// of 0 never refuses. The claim is made under the same lock as the check,
+// so mounts running at the same time cannot both fit in the last space.
+func (a *cacheAccount) reserve(name string, c cacheClaim, limit uint64) (uint64, bool) {
+	a.Lock()
+	defer a.Unlock()
+	total := a.totalLocked(name, &c)
+	if limit != 0 && total > limit {
+		return total, false
+	}
+	a.claims[name] = c
+	return total, true
+}
+
+func (a *cacheAccount) totalLocked(name string, extra *cacheClaim) uint64 {
+	paths := make(map[string]uint64)
+	for n, c := range a.claims {
+		if n != name && c.size > paths[c.path] {
+			paths[c.path] = c.size
+		}
+	}
+	if extra != nil && extra.size > paths[extra.path] {
+		paths[extra.path] = extra.size
+	}
+	var total uint64
+	for _, size := range paths {
+		total += size
+	}
+	return total
+}
+
+// checkCacheLimit compares the disk caches with v mounted against
+// OBJECTIVEFS_CACHE_LIMIT, warning from 90% and refusing the mount above
+// the limit if configured. The cache of v is reserved when it fits; the
+// caller releases it with caches.unset if the mount then fails.
+func (d *ofsDriver) checkCacheLimit(v *ofsVolume) error {
+	c, ok := diskCache(v)
+	if d.config.cacheLimit == 0 || !ok {
+		return nil
+	}
+	limit := uint64(0)
+	if d.config.cacheLimitRefuse {
+		limit = d.config.cacheLimit
+	}
+	total, ok := caches.reserve(v.volume.Name, c, limit)
+	if !ok {
+		return fmt.Errorf("disk caches would take %d bytes with volume '%s', above the limit of %d", total, v.volume.Name, d.config.cacheLimit)
+	}
+	if total > d.config.cacheLimit/10*9 {
+		log.Printf("Warning: disk caches take %d bytes with ObjectiveFS Volume '%s', limit %d", total, v.volume.Name, d.config.cacheLimit)
+	}
+	return nil
+}
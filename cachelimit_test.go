@@ -0,0 +1,140 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestDiskCache(t *testing.T) {
+	tests := []struct {
+		opts map[string]string
+		want cacheClaim
+		ok   bool
+	}{
+		{map[string]string{"fs": "myfs"}, cacheClaim{}, false},
+		{map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "10G"}, cacheClaim{defaultCachePath, 10 << 30}, true},
+		{map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "10G:2G", "DISKCACHE_PATH": "/cache"}, cacheClaim{"/cache", 10 << 30}, true},
+		{map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "big"}, cacheClaim{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := diskCache(testVolume(t, tt.opts))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("diskCache(%v) = %+v, %v, want %+v", tt.opts, got, ok, tt.want)
+		}
+	}
+}
+
+func TestCacheTotal(t *testing.T) {
+	a := &cacheAccount{claims: map[string]cacheClaim{
+		"a": {"/cache", 10},
+		"b": {"/cache", 30},
+		"c": {"/other", 5},
+	}}
+	tests := []struct {
+		name  string
+		extra *cacheClaim
+		want  uint64
+	}{
+		{"", nil, 35},
+		{"b", nil, 15},
+		{"d", &cacheClaim{"/cache", 20}, 35},
+		{"d", &cacheClaim{"/cache", 50}, 55},
+		{"d", &cacheClaim{"/third", 1}, 36},
+		{"b", &cacheClaim{"/cache", 20}, 25},
+	}
+	for _, tt := range tests {
+		if got := a.total(tt.name, tt.extra); got != tt.want {
+			t.Errorf("total(%q, %+v) = %d, want %d", tt.name, tt.extra, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCacheLimit(t *testing.T) {
+	old := caches
+	caches = &cacheAccount{claims: map[string]cacheClaim{"other": {defaultCachePath, 6 << 30}}}
+	defer func() { caches = old }()
+	tests := []struct {
+		limit  uint64
+		refuse bool
+		opts   map[string]string
+		ok     bool
+	}{
+		{0, true, map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "100G"}, true},
+		{10 << 30, true, map[string]string{"fs": "myfs"}, true},
+		{10 << 30, true, map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "8G"}, true},
+		{10 << 30, true, map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "5G", "DISKCACHE_PATH": "/cache"}, false},
+		{10 << 30, false, map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "5G", "DISKCACHE_PATH": "/cache"}, true},
+		{10 << 30, true, map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "11G"}, false},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.cacheLimit, d.config.cacheLimitRefuse = tt.limit, tt.refuse
+		if err := d.checkCacheLimit(testVolume(t, tt.opts)); (err == nil) != tt.ok {
+			t.Errorf("limit %d, refuse %v, %v: %v, want ok %v", tt.limit, tt.refuse, tt.opts, err, tt.ok)
+		}
+	}
+}
+
+func TestReserveConcurrent(t *testing.T) {
+	a := &cacheAccount{claims: map[string]cacheClaim{"other": {defaultCachePath, 6 << 30}}}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, ok := a.reserve("vol"+strconv.Itoa(i), cacheClaim{"/cache" + strconv.Itoa(i), 3 << 30}, 10<<30); ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if reserved != 1 || a.total("", nil) != 9<<30 {
+		t.Errorf("%d reservations taking %d bytes, want 1 within the limit", reserved, a.total("", nil))
+	}
+}
+
+func TestCacheReleasedOnFailedMount(t *testing.T) {
+	old := caches
+	caches = &cacheAccount{claims: make(map[string]cacheClaim)}
+	defer func() { caches = old }()
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	d.config.cacheLimit, d.config.cacheLimitRefuse = 10<<30, true
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "DISKCACHE_SIZE": "8G"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.fail(t, "fail", "bucket not found")
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err == nil {
+		t.Fatal("mount succeeded")
+	}
+	if total := caches.total("", nil); total != 0 {
+		t.Errorf("failed mount left %d bytes of cache reserved", total)
+	}
+	f.fail(t, "fail", "")
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if total := caches.total("", nil); total != 8<<30 {
+		t.Errorf("mounted volume has %d bytes of cache reserved", total)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// tripped tells if the crash breaker of v is open, closing it again once
+// the cooldown has passed.
+func (d *ofsDriver) tripped(v *ofsVolume, now time.Time) bool {
+	if v.trippedAt.IsZero() {
+		return false
+	}
+	if d.config.crashCooldown > 0 && now.Sub(v.trippedAt) >= d.config.crashCooldown {
+		log.Printf("Re-enabling ObjectiveFS Volume '%s' after %s cooldown", v.volume.Name, d.config.crashCooldown)
+		d.resetBreaker(v)
+		return false
+	}
+	return true
+}
+
+func (d *ofsDriver) resetBreaker(v *ofsVolume) {
+	v.crashes = nil
+	v.trippedAt = time.Time{}
+	metrics.set("objectivefs_crash_breaker_open", 0, "volume", v.volume.Name)
+}
+
+func (d *ofsDriver) breakerError(v *ofsVolume) error {
+	return fmt.Errorf("volume '%s' disabled after %d crashes, reset with POST /volumes/%s/reset", v.volume.Name, len(v.crashes), v.volume.Name)
+}
+
+// crashed records a crash of the ObjectiveFS process of v and remounts it,
+// unless it crashed too often within the crash window. In that case the
+// breaker opens and v stays down until it is reset.
+func (d *ofsDriver) crashed(v *ofsVolume) {
+	now := time.Now()
+	metrics.inc("objectivefs_crashes_total", "volume", v.volume.Name)
+	v.crashes = append(v.crashes[expired(v.crashes, d.config.crashWindow, now):], now)
+	if len(v.crashes) >= d.config.crashLimit {
+		log.Printf("ObjectiveFS Volume '%s' crashed %d times within %s, not remounting", v.volume.Name, len(v.crashes), d.config.crashWindow)
+		v.trippedAt = now
+		metrics.set("objectivefs_crash_breaker_open", 1, "volume", v.volume.Name)
+		d.notify("crash_loop", v.volume.Name, "crashes", strconv.Itoa(len(v.crashes)))
+		return
+	}
+	log.Printf("ObjectiveFS Volume '%s' crashed, remounting", v.volume.Name)
+	if err := d.umount(v, d.config.unmountPolicy); err != nil {
+		log.Printf("Remount of ObjectiveFS Volume '%s' failed: %s", v.volume.Name, err.Error())
+		return
+	}
+	if err := d.mount(v); err != nil {
+		log.Printf("Remount of ObjectiveFS Volume '%s' failed: %s", v.volume.Name, err.Error())
+	}
+}
+
+// checkVolume runs the periodic health check of v, with v locked.
+func (d *ofsDriver) checkVolume(v *ofsVolume) {
+	if !v.mounted || d.tripped(v, time.Now()) {
+		return
+	}
+	switch state := volumeState(v); state {
+	case stateOk:
+	case stateDisconnect:
+		d.setHealth(v, state)
+		d.crashed(v)
+		return
+	default:
+		d.setHealth(v, state)
+		return
+	}
+	if d.checkIO(v, time.Now()) {
+		d.setHealth(v, "io errors")
+	} else {
+		d.setHealth(v, "")
+	}
+	d.checkExpiry(v, time.Now())
+	if v.maxAge != 0 && ageRemaining(v, time.Now()) == 0 {
+		d.rotate(v)
+	}
+}
+
+// checkIO reads the root directory of v and counts the reads failing with
+// EIO, which the object store or the key causes while the ObjectiveFS
+// process itself is still running. A lost process, ENOTCONN, is a crash.
+// It tells if the IO error limit is reached.
+func (d *ofsDriver) checkIO(v *ofsVolume, now time.Time) bool {
+	_, err := probeFirstByte(v.volume.Mountpoint, d.config.probeTimeout)
+	if err == nil || !errors.Is(err, syscall.EIO) {
+		return d.config.ioErrorLimit > 0 && len(v.ioErrors)-expired(v.ioErrors, d.config.ioErrorWindow, now) >= d.config.ioErrorLimit
+	}
+	metrics.inc("objectivefs_io_errors_total", "volume", v.volume.Name)
+	v.ioErrors = append(v.ioErrors[expired(v.ioErrors, d.config.ioErrorWindow, now):], now)
+	log.Printf("IO error reading ObjectiveFS Volume '%s' (%d within %s)", v.volume.Name, len(v.ioErrors), d.config.ioErrorWindow)
+	if len(v.ioErrors) == d.config.ioErrorLimit {
+		d.notify("io_errors", v.volume.Name, "errors", strconv.Itoa(len(v.ioErrors)), "window", d.config.ioErrorWindow.String())
+	}
+	return d.config.ioErrorLimit > 0 && len(v.ioErrors) >= d.config.ioErrorLimit
+}
+
+// ageRemaining returns how long the mount of v may still live before it
+// is due for rotation.
+func ageRemaining(v *ofsVolume, now time.Time) time.Duration {
+	if left := v.mountedAt.Add(v.maxAge).Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// rotate remounts v once its mount reached max_mount_age, so rotated
+// credentials and configuration are picked up. A volume in use is only
+// flagged as due until its last user is gone.
+func (d *ofsDriver) rotate(v *ofsVolume) {
+	if len(v.use) != 0 {
+		if !v.rotationDue {
+			log.Printf("ObjectiveFS Volume '%s' reached max_mount_age %s, remounting once unused", v.volume.Name, v.maxAge)
+			d.notify("rotation_due", v.volume.Name)
+		}
+		v.rotationDue = true
+		return
+	}
+	log.Printf("ObjectiveFS Volume '%s' reached max_mount_age %s, remounting", v.volume.Name, v.maxAge)
+	if err := d.umount(v, d.config.unmountPolicy); err != nil {
+		log.Printf("Remount of ObjectiveFS Volume '%s' failed: %s", v.volume.Name, err.Error())
+		return
+	}
+	if err := d.mount(v); err != nil {
+		log.Printf("Remount of ObjectiveFS Volume '%s' failed: %s", v.volume.Name, err.Error())
+	}
+}
+
+func (d *ofsDriver) checkLoop() {
+	for range time.Tick(d.config.healthInterval) {
+		for _, v := range d.snapshot() {
+			v.Lock()
+			if !v.removed {
+				d.checkVolume(v)
+			}
+			v.Unlock()
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestTripped(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		trippedAt time.Time
+		cooldown  time.Duration
+		want      bool
+	}{
+		{time.Time{}, 0, false},
+		{now.Add(-time.Hour), 0, true},
+		{now.Add(-time.Minute), time.Hour, true},
+		{now.Add(-time.Hour), time.Hour, false},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.crashCooldown = tt.cooldown
+		v := testVolume(t, map[string]string{"fs": "myfs"})
+		v.trippedAt, v.crashes = tt.trippedAt, []time.Time{now}
+		if got := d.tripped(v, now); got != tt.want {
+			t.Errorf("tripped(%s ago, cooldown %s) = %v, want %v", now.Sub(tt.trippedAt), tt.cooldown, got, tt.want)
+		}
+		if !tt.want && !tt.trippedAt.IsZero() && (!v.trippedAt.IsZero() || v.crashes != nil) {
+			t.Errorf("breaker closed after %s cooldown but not reset", tt.cooldown)
+		}
+	}
+}
+
+func TestCrashed(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	d.config.crashLimit = 3
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	for i := 1; i < d.config.crashLimit; i++ {
+		d.crashed(v)
+		if n := f.helperCalls(); n != i+1 || !v.mounted || d.tripped(v, time.Now()) {
+			t.Fatalf("crash %d: %d mounts, mounted %v, want a remount", i, n, v.mounted)
+		}
+	}
+	d.crashed(v)
+	if n := f.helperCalls(); n != d.config.crashLimit || !d.tripped(v, time.Now()) {
+		t.Errorf("crash %d: %d mounts, tripped %v, want the breaker open", d.config.crashLimit, n, d.tripped(v, time.Now()))
+	}
+	if err := d.breakerError(v); err == nil {
+		t.Error("no breaker error")
+	}
+	// A volume behind an open breaker is not checked at all.
+	d.checkVolume(v)
+	if n := f.helperCalls(); n != d.config.crashLimit {
+		t.Errorf("checkVolume remounted a tripped volume")
+	}
+	d.resetBreaker(v)
+	if d.tripped(v, time.Now()) || len(v.crashes) != 0 {
+		t.Error("resetBreaker left the breaker open")
+	}
+}
+
+func TestAgeRemaining(t *testing.T) {
+	now := time.Now()
+	v := &ofsVolume{maxAge: time.Hour}
+	for age, want := range map[time.Duration]time.Duration{0: time.Hour, 20 * time.Minute: 40 * time.Minute, time.Hour: 0, 2 * time.Hour: 0} {
+		v.mountedAt = now.Add(-age)
+		if got := ageRemaining(v, now); got != want {
+			t.Errorf("ageRemaining after %s = %s, want %s", age, got, want)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	for val, ok := range map[string]bool{"1h": true, "1m": true, "59s": false, "soon": false} {
+		if _, err := newVolume("vol", map[string]string{"fs": "myfs", "max_mount_age": val}, ""); (err == nil) != ok {
+			t.Errorf("max_mount_age=%s: %v, want ok %v", val, err, ok)
+		}
+	}
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "max_mount_age": "1h"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	v.mountedAt = v.mountedAt.Add(-2 * time.Hour)
+	d.rotate(v)
+	if !v.rotationDue || f.helperCalls() != 1 {
+		t.Errorf("volume in use: due %v after %d mounts, want flagged only", v.rotationDue, f.helperCalls())
+	}
+	delete(v.use, "c")
+	d.rotate(v)
+	if v.rotationDue || f.helperCalls() != 2 || !v.mounted || ageRemaining(v, time.Now()) < 59*time.Minute {
+		t.Errorf("unused volume: due %v after %d mounts, want remounted", v.rotationDue, f.helperCalls())
+	}
+}
+
+func TestCheckIO(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		limit  int
+		errors []time.Duration
+		want   bool
+	}{
+		{0, []time.Duration{time.Second, time.Second, time.Second}, false},
+		{3, []time.Duration{30 * time.Second, 20 * time.Second, 10 * time.Second}, true},
+		{3, []time.Duration{2 * time.Minute, 20 * time.Second, 10 * time.Second}, false},
+		{3, []time.Duration{3 * time.Minute, 2 * time.Minute, time.Minute + time.Second}, false},
+		{1, []time.Duration{time.Second}, true},
+		{1, nil, false},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.ioErrorLimit, d.config.ioErrorWindow, d.config.probeTimeout = tt.limit, time.Minute, time.Second
+		v := testVolume(t, map[string]string{"fs": "myfs"})
+		v.volume.Mountpoint = t.TempDir()
+		for _, ago := range tt.errors {
+			v.ioErrors = append(v.ioErrors, now.Add(-ago))
+		}
+		if got := d.checkIO(v, now); got != tt.want {
+			t.Errorf("limit %d, errors %s ago: %v, want %v", tt.limit, tt.errors, got, tt.want)
+		}
+		if len(v.ioErrors) != len(tt.errors) {
+			t.Errorf("a read without IO error was counted")
+		}
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// ofsConfig holds the driver wide settings, read from the plugin
+// environment at startup.
+type ofsConfig struct {
+	strictUnmount bool
+	mountWrapper  []string
+	syncInterval  time.Duration
+	syncTimeout   time.Duration
+	auditLog      string
+	lockFile      string
+	pathUnmounted string
+	probeTimeout  time.Duration
+	mountStyle    string
+	mountBinary   string
+	metricsAddr   string
+	metricsRetry  time.Duration
+	mountBudget   int
+	budgetWindow  time.Duration
+
+	requireRootGid       bool
+	webhookURL           string
+	healthInterval       time.Duration
+	crashLimit           int
+	crashWindow          time.Duration
+	crashCooldown        time.Duration
+	otlpEndpoint         string
+	volumesFile          string
+	volumesPrune         bool
+	unmountPolicy        string
+	removeUnmount        string
+	mountRoot            string
+	socket               string
+	stateFile            string
+	usePersist           string
+	useInterval          time.Duration
+	memoryCheck          string
+	memoryMargin         uint64
+	unmountOnExit        bool
+	passphraseMinLength  int
+	passphraseMinEntropy int
+	timeouts             map[string]time.Duration
+	endpointLimit        int
+	volumeLogDir         string
+	profilesFile         string
+	precreate            bool
+	mountpointMode       os.FileMode
+	mountpointUid        int
+	mountpointGid        int
+	credentialsCommand   []string
+	credentialsTimeout   time.Duration
+	cacheLimit           uint64
+	cacheLimitRefuse     bool
+	startupGrace         time.Duration
+	orphanPolicy         string
+	ioErrorLimit         int
+	ioErrorWindow        time.Duration
+	allowHooks           bool
+	hookTimeout          time.Duration
+	ioStatsInterval      time.Duration
+	syncBeforeUnmount    bool
+	driverName           string
+	specDir              string
+	showUsers            string
+	idleRemoveAge        time.Duration
+	admitMinMemory       uint64
+	admitMinFDs          int
+	admitMaxFuse         int
+	expiryWarning        time.Duration
+
+	allowSharedPropagation bool
+	licenseRetries         int
+	licenseRetryDelay      time.Duration
+}
+
+func envBool(key string, def bool) (bool, error) {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return def, fmt.Errorf("invalid value for %s: '%s'", key, val)
+	}
+	return b, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def, nil
+	}
+	t, err := time.ParseDuration(val)
+	if err != nil || t < 0 {
+		return def, fmt.Errorf("invalid value for %s: '%s'", key, val)
+	}
+	return t, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil || i < 0 {
+		return def, fmt.Errorf("invalid value for %s: '%s'", key, val)
+	}
+	return i, nil
+}
+
+func envPolicy(key string) (string, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return umountNormal, nil
+	}
+	if _, ok := umountSteps[val]; !ok {
+		return "", fmt.Errorf("invalid value for %s: '%s'", key, val)
+	}
+	return val, nil
+}
+
+func loadConfig() (ofsConfig, error) {
+	var c ofsConfig
+	var err error
+	if c.strictUnmount, err = envBool("OBJECTIVEFS_UNMOUNT_STRICT", false); err != nil {
+		return c, err
+	}
+	if c.syncInterval, err = envDuration("OBJECTIVEFS_SYNC_INTERVAL", 0); err != nil {
+		return c, err
+	}
+	if c.syncTimeout, err = envDuration("OBJECTIVEFS_SYNC_TIMEOUT", time.Minute); err != nil {
+		return c, err
+	}
+	if c.syncBeforeUnmount, err = envBool("OBJECTIVEFS_SYNC_BEFORE_UNMOUNT", true); err != nil {
+		return c, err
+	}
+	switch c.pathUnmounted = os.Getenv("OBJECTIVEFS_PATH_UNMOUNTED"); c.pathUnmounted {
+	case "":
+		c.pathUnmounted = "mountpoint"
+	case "mountpoint", "empty", "error":
+	default:
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_PATH_UNMOUNTED: '%s'", c.pathUnmounted)
+	}
+	c.metricsAddr = os.Getenv("OBJECTIVEFS_METRICS_ADDR")
+	if c.metricsRetry, err = envDuration("OBJECTIVEFS_METRICS_RETRY", time.Minute); err != nil {
+		return c, err
+	}
+	if c.mountBudget, err = envInt("OBJECTIVEFS_MOUNT_BUDGET", 0); err != nil {
+		return c, err
+	}
+	if c.budgetWindow, err = envDuration("OBJECTIVEFS_MOUNT_BUDGET_WINDOW", 10*time.Minute); err != nil {
+		return c, err
+	}
+	if c.requireRootGid, err = envBool("OBJECTIVEFS_REQUIRE_ROOT_GID", false); err != nil {
+		return c, err
+	}
+	c.webhookURL = os.Getenv("OBJECTIVEFS_WEBHOOK_URL")
+	if c.healthInterval, err = envDuration("OBJECTIVEFS_HEALTH_INTERVAL", 0); err != nil {
+		return c, err
+	}
+	if c.crashLimit, err = envInt("OBJECTIVEFS_CRASH_LIMIT", 3); err != nil {
+		return c, err
+	}
+	if c.crashLimit == 0 {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_CRASH_LIMIT: '0'")
+	}
+	if c.crashWindow, err = envDuration("OBJECTIVEFS_CRASH_WINDOW", 10*time.Minute); err != nil {
+		return c, err
+	}
+	if c.crashCooldown, err = envDuration("OBJECTIVEFS_CRASH_COOLDOWN", 0); err != nil {
+		return c, err
+	}
+	c.otlpEndpoint = os.Getenv("OBJECTIVEFS_OTLP_ENDPOINT")
+	c.volumesFile = os.Getenv("OBJECTIVEFS_VOLUMES_FILE")
+	if c.volumesPrune, err = envBool("OBJECTIVEFS_VOLUMES_PRUNE", false); err != nil {
+		return c, err
+	}
+	if c.unmountPolicy, err = envPolicy("OBJECTIVEFS_UNMOUNT_POLICY"); err != nil {
+		return c, err
+	}
+	if c.removeUnmount, err = envPolicy("OBJECTIVEFS_REMOVE_UNMOUNT_POLICY"); err != nil {
+		return c, err
+	}
+	if c.unmountOnExit, err = envBool("OBJECTIVEFS_UNMOUNT_ON_EXIT", false); err != nil {
+		return c, err
+	}
+	if c.mountRoot = os.Getenv("OBJECTIVEFS_MOUNT_ROOT"); c.mountRoot == "" {
+		c.mountRoot = filepath.Join(volume.DefaultDockerRootDirectory, "objectivefs")
+	}
+	if !filepath.IsAbs(c.mountRoot) {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_MOUNT_ROOT: '%s' is not absolute", c.mountRoot)
+	}
+	c.mountRoot = filepath.Clean(c.mountRoot)
+	if c.socket = os.Getenv("OBJECTIVEFS_SOCKET"); c.socket == "" {
+		c.socket = "objectivefs"
+	}
+	if !filepath.IsAbs(c.socket) && !driverNameRe.MatchString(c.socket) {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_SOCKET: '%s'", c.socket)
+	}
+	if c.driverName = os.Getenv("OBJECTIVEFS_DRIVER_NAME"); c.driverName == "" {
+		c.driverName = strings.TrimSuffix(filepath.Base(c.socket), ".sock")
+	}
+	if !driverNameRe.MatchString(c.driverName) {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_DRIVER_NAME: '%s'", c.driverName)
+	}
+	if c.specDir = os.Getenv("OBJECTIVEFS_SPEC_DIR"); c.specDir != "" && !filepath.IsAbs(c.specDir) {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_SPEC_DIR: '%s' is not absolute", c.specDir)
+	}
+	if c.precreate, err = envBool("OBJECTIVEFS_PRECREATE_MOUNTPOINT", false); err != nil {
+		return c, err
+	}
+	c.mountpointMode = 0755
+	if val := os.Getenv("OBJECTIVEFS_MOUNTPOINT_MODE"); val != "" {
+		mode, err := strconv.ParseUint(val, 8, 32)
+		if err != nil || mode > 0777 {
+			return c, fmt.Errorf("invalid value for OBJECTIVEFS_MOUNTPOINT_MODE: '%s'", val)
+		}
+		c.mountpointMode = os.FileMode(mode)
+	}
+	c.mountpointUid, c.mountpointGid = -1, -1
+	if val := os.Getenv("OBJECTIVEFS_MOUNTPOINT_OWNER"); val != "" {
+		ids := strings.SplitN(val, ":", 2)
+		uid, uerr := strconv.Atoi(ids[0])
+		gid, gerr := uid, uerr
+		if len(ids) == 2 {
+			gid, gerr = strconv.Atoi(ids[1])
+		}
+		if uerr != nil || gerr != nil || uid < 0 || gid < 0 {
+			return c, fmt.Errorf("invalid value for OBJECTIVEFS_MOUNTPOINT_OWNER: '%s'", val)
+		}
+		c.mountpointUid, c.mountpointGid = uid, gid
+	}
+	c.stateFile = os.Getenv("OBJECTIVEFS_STATE_FILE")
+	c.profilesFile = os.Getenv("OBJECTIVEFS_PROFILES_FILE")
+	switch c.usePersist = os.Getenv("OBJECTIVEFS_USE_PERSIST"); c.usePersist {
+	case "":
+		c.usePersist = "interval"
+	case "immediate", "interval", "never":
+	default:
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_USE_PERSIST: '%s'", c.usePersist)
+	}
+	if c.useInterval, err = envDuration("OBJECTIVEFS_USE_INTERVAL", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.usePersist == "interval" && c.useInterval == 0 {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_USE_INTERVAL: '0'")
+	}
+	switch c.memoryCheck = os.Getenv("OBJECTIVEFS_MEMORY_CHECK"); c.memoryCheck {
+	case "":
+		c.memoryCheck = "off"
+	case "off", "warn", "refuse":
+	default:
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_MEMORY_CHECK: '%s'", c.memoryCheck)
+	}
+	c.memoryMargin = 256 << 20
+	if val := os.Getenv("OBJECTIVEFS_MEMORY_MARGIN"); val != "" {
+		if c.memoryMargin, err = parseSize(val); err != nil {
+			return c, fmt.Errorf("invalid value for OBJECTIVEFS_MEMORY_MARGIN: '%s'", val)
+		}
+	}
+	if c.passphraseMinLength, err = envInt("OBJECTIVEFS_PASSPHRASE_MIN_LENGTH", 0); err != nil {
+		return c, err
+	}
+	if c.passphraseMinEntropy, err = envInt("OBJECTIVEFS_PASSPHRASE_MIN_ENTROPY", 0); err != nil {
+		return c, err
+	}
+	if c.volumeLogDir = os.Getenv("OBJECTIVEFS_VOLUME_LOG_DIR"); c.volumeLogDir != "" && !filepath.IsAbs(c.volumeLogDir) {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_VOLUME_LOG_DIR: '%s' is not absolute", c.volumeLogDir)
+	}
+	if val := os.Getenv("OBJECTIVEFS_CACHE_LIMIT"); val != "" {
+		if c.cacheLimit, err = parseSize(val); err != nil {
+			return c, fmt.Errorf("invalid value for OBJECTIVEFS_CACHE_LIMIT: '%s'", val)
+		}
+	}
+	if c.cacheLimitRefuse, err = envBool("OBJECTIVEFS_CACHE_LIMIT_REFUSE", false); err != nil {
+		return c, err
+	}
+	if c.startupGrace, err = envDuration("OBJECTIVEFS_STARTUP_GRACE", 0); err != nil {
+		return c, err
+	}
+	switch c.orphanPolicy = os.Getenv("OBJECTIVEFS_ORPHAN_POLICY"); c.orphanPolicy {
+	case "":
+		c.orphanPolicy = "keep"
+	case "keep", "remove":
+	default:
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_ORPHAN_POLICY: '%s'", c.orphanPolicy)
+	}
+	if c.ioErrorLimit, err = envInt("OBJECTIVEFS_IO_ERROR_LIMIT", 3); err != nil {
+		return c, err
+	}
+	if c.ioErrorWindow, err = envDuration("OBJECTIVEFS_IO_ERROR_WINDOW", 10*time.Minute); err != nil {
+		return c, err
+	}
+	if c.allowHooks, err = envBool("OBJECTIVEFS_ALLOW_HOOKS", false); err != nil {
+		return c, err
+	}
+	if c.hookTimeout, err = envDuration("OBJECTIVEFS_HOOK_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.ioStatsInterval, err = envDuration("OBJECTIVEFS_IO_STATS_INTERVAL", 0); err != nil {
+		return c, err
+	}
+	switch c.showUsers = os.Getenv("OBJECTIVEFS_SHOW_USERS"); c.showUsers {
+	case "":
+		c.showUsers = "short"
+	case "full", "short", "none":
+	default:
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_SHOW_USERS: '%s'", c.showUsers)
+	}
+	if c.idleRemoveAge, err = envDuration("OBJECTIVEFS_IDLE_REMOVE_AGE", 0); err != nil {
+		return c, err
+	}
+	if val := os.Getenv("OBJECTIVEFS_ADMIT_MIN_MEMORY"); val != "" {
+		if c.admitMinMemory, err = parseSize(val); err != nil {
+			return c, fmt.Errorf("invalid value for OBJECTIVEFS_ADMIT_MIN_MEMORY: '%s'", val)
+		}
+	}
+	if c.admitMinFDs, err = envInt("OBJECTIVEFS_ADMIT_MIN_FDS", 0); err != nil {
+		return c, err
+	}
+	if c.admitMaxFuse, err = envInt("OBJECTIVEFS_ADMIT_MAX_FUSE_MOUNTS", 0); err != nil {
+		return c, err
+	}
+	if c.expiryWarning, err = envDuration("OBJECTIVEFS_CREDENTIALS_EXPIRY_WARNING", time.Hour); err != nil {
+		return c, err
+	}
+	if c.allowSharedPropagation, err = envBool("OBJECTIVEFS_ALLOW_SHARED_PROPAGATION", false); err != nil {
+		return c, err
+	}
+	if c.licenseRetries, err = envInt("OBJECTIVEFS_LICENSE_RETRIES", 3); err != nil {
+		return c, err
+	}
+	if c.licenseRetryDelay, err = envDuration("OBJECTIVEFS_LICENSE_RETRY_DELAY", 2*time.Second); err != nil {
+		return c, err
+	}
+	c.auditLog = os.Getenv("OBJECTIVEFS_AUDIT_LOG")
+	if c.lockFile = os.Getenv("OBJECTIVEFS_LOCK_FILE"); c.lockFile == "" {
+		c.lockFile = "/run/docker/plugins/objectivefs.pid"
+	}
+	c.timeouts = make(map[string]time.Duration)
+	for _, kind := range timeoutKinds {
+		key := "OBJECTIVEFS_" + strings.ToUpper(kind) + "_TIMEOUT"
+		if c.timeouts[kind], err = envDuration(key, 0); err != nil {
+			return c, err
+		}
+	}
+	if c.endpointLimit, err = envInt("OBJECTIVEFS_ENDPOINT_MOUNT_LIMIT", 0); err != nil {
+		return c, err
+	}
+	if c.probeTimeout, err = envDuration("OBJECTIVEFS_PROBE_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	switch c.mountStyle = os.Getenv("OBJECTIVEFS_MOUNT_STYLE"); c.mountStyle {
+	case "", "helper":
+		c.mountStyle, c.mountBinary = "helper", "/sbin/mount.objectivefs"
+	case "subcommand":
+		c.mountBinary = "objectivefs"
+	default:
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_MOUNT_STYLE: '%s'", c.mountStyle)
+	}
+	if bin := os.Getenv("OBJECTIVEFS_MOUNT_BINARY"); bin != "" {
+		c.mountBinary = bin
+	}
+	bin, err := exec.LookPath(c.mountBinary)
+	if err != nil {
+		return c, fmt.Errorf("ObjectiveFS %s '%s' not found: %s", c.mountStyle, c.mountBinary, err.Error())
+	}
+	c.mountBinary = bin
+	if c.mountWrapper = strings.Fields(os.Getenv("OBJECTIVEFS_MOUNT_WRAPPER")); len(c.mountWrapper) != 0 {
+		if _, err := exec.LookPath(c.mountWrapper[0]); err != nil {
+			return c, fmt.Errorf("invalid OBJECTIVEFS_MOUNT_WRAPPER: %s", err.Error())
+		}
+	}
+	if c.credentialsCommand = strings.Fields(os.Getenv("OBJECTIVEFS_CREDENTIALS_COMMAND")); len(c.credentialsCommand) != 0 {
+		if _, err := exec.LookPath(c.credentialsCommand[0]); err != nil {
+			return c, fmt.Errorf("invalid OBJECTIVEFS_CREDENTIALS_COMMAND: %s", err.Error())
+		}
+	}
+	if c.credentialsTimeout, err = envDuration("OBJECTIVEFS_CREDENTIALS_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.credentialsTimeout == 0 {
+		return c, fmt.Errorf("invalid value for OBJECTIVEFS_CREDENTIALS_TIMEOUT: '0'")
+	}
+	return c, nil
+}
+
+// mountCommand returns the command mounting an ObjectiveFS filesystem,
+// either as mount.objectivefs <args> or as objectivefs mount <args>, run
+// through the per volume prefix command, if any.
+func (c ofsConfig) mountCommand(prefix []string, args ...string) *exec.Cmd {
+	if c.mountStyle == "subcommand" {
+		args = append([]string{"mount"}, args...)
+	}
+	argv := append(append(append([]string{}, prefix...), c.mountBinary), args...)
+	return c.command(argv[0], argv[1:]...)
+}
+
+var timeoutKinds = []string{"mount", "unmount", "verify"}
+
+// timeout returns the timeout of v for the given kind of operation: its
+// own from the <kind>_timeout option, or else the driver default.
+func (d *ofsDriver) timeout(v *ofsVolume, kind string) time.Duration {
+	if t, ok := v.timeouts[kind]; ok {
+		return t
+	}
+	return d.config.timeouts[kind]
+}
+
+var errCommandTimeout = errors.New("timed out")
+
+// run runs cmd, killing it when it has not finished within timeout, and
+// returns its combined output. A timeout of 0 waits without limit. The
+// output goes to a file rather than a pipe, since the daemonized
+// ObjectiveFS process inherits it and keeps it open for as long as it
+// runs.
+func run(cmd *exec.Cmd, timeout time.Duration) (string, error) {
+	f, err := ioutil.TempFile("", "objectivefs-output-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	cmd.Stdout, cmd.Stderr = f, f
+	err = wait(cmd, timeout)
+	if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+		return "", serr
+	}
+	out, _ := ioutil.ReadAll(f)
+	return string(out), err
+}
+
+// wait runs cmd to completion, or kills it after timeout if that is not 0.
+func wait(cmd *exec.Cmd, timeout time.Duration) error {
+	if timeout == 0 {
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	t := time.AfterFunc(timeout, func() { cmd.Process.Kill() })
+	err := cmd.Wait()
+	if !t.Stop() {
+		return errCommandTimeout
+	}
+	return err
+}
+
+// command returns the mount or umount command, run through the configured
+// mount wrapper if there is one.
+func (c ofsConfig) command(name string, args ...string) *exec.Cmd {
+	if len(c.mountWrapper) == 0 {
+		return exec.Command(name, args...)
+	}
+	argv := append(append(c.mountWrapper[1:len(c.mountWrapper):len(c.mountWrapper)], name), args...)
+	return exec.Command(c.mountWrapper[0], argv...)
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		wrapper []string
+		want    []string
+	}{
+		{nil, []string{"umount", "-l", "/mnt"}},
+		{[]string{"/usr/bin/firejail", "--quiet"}, []string{"/usr/bin/firejail", "--quiet", "umount", "-l", "/mnt"}},
+	}
+	for _, tt := range tests {
+		c := ofsConfig{mountWrapper: tt.wrapper}
+		if got := c.command("umount", "-l", "/mnt").Args; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapper %q: %q, want %q", tt.wrapper, got, tt.want)
+		}
+		// The wrapper arguments must not be changed by appending to them.
+		c.command("mount", "a")
+		if len(tt.wrapper) != 0 && !reflect.DeepEqual(c.command("umount", "-l", "/mnt").Args, tt.want) {
+			t.Errorf("wrapper %q changed by an earlier command", tt.wrapper)
+		}
+	}
+}
+
+func TestMountCommand(t *testing.T) {
+	tests := []struct {
+		style  string
+		prefix []string
+		want   []string
+	}{
+		{"helper", nil, []string{"/sbin/mount.objectivefs", "-oauto", "fs", "/mnt"}},
+		{"subcommand", nil, []string{"/sbin/mount.objectivefs", "mount", "-oauto", "fs", "/mnt"}},
+		{"helper", []string{"/usr/bin/nice", "-n", "5"}, []string{"/usr/bin/nice", "-n", "5", "/sbin/mount.objectivefs", "-oauto", "fs", "/mnt"}},
+	}
+	for _, tt := range tests {
+		c := ofsConfig{mountStyle: tt.style, mountBinary: "/sbin/mount.objectivefs"}
+		if got := c.mountCommand(tt.prefix, "-oauto", "fs", "/mnt").Args; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: %q, want %q", tt.style, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigMountStyle(t *testing.T) {
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	for style, ok := range map[string]bool{"": true, "helper": true, "subcommand": true, "direct": false} {
+		t.Setenv("OBJECTIVEFS_MOUNT_STYLE", style)
+		c, err := loadConfig()
+		if (err == nil) != ok {
+			t.Errorf("OBJECTIVEFS_MOUNT_STYLE=%q: %v, want ok %v", style, err, ok)
+		}
+		if ok && c.mountBinary != "/bin/true" {
+			t.Errorf("OBJECTIVEFS_MOUNT_STYLE=%q: binary %q", style, c.mountBinary)
+		}
+	}
+	t.Setenv("OBJECTIVEFS_MOUNT_STYLE", "")
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/nonexistent/mount.objectivefs")
+	if _, err := loadConfig(); err == nil {
+		t.Error("missing mount binary accepted")
+	}
+}
+
+func TestLoadConfigUnmountPolicies(t *testing.T) {
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	t.Setenv("OBJECTIVEFS_REMOVE_UNMOUNT_POLICY", "force")
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.unmountPolicy != umountNormal || c.removeUnmount != umountForce {
+		t.Errorf("policies %q and %q, want %q and %q", c.unmountPolicy, c.removeUnmount, umountNormal, umountForce)
+	}
+	for _, key := range []string{"OBJECTIVEFS_UNMOUNT_POLICY", "OBJECTIVEFS_REMOVE_UNMOUNT_POLICY"} {
+		t.Setenv(key, "gentle")
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("%s=gentle accepted", key)
+		}
+		t.Setenv(key, "lazy")
+	}
+}
+
+func TestLoadConfigPluginEnv(t *testing.T) {
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	tests := []struct {
+		root, socket, name string
+		wantRoot, wantName string
+	}{
+		{"", "", "", "/var/lib/docker-volumes/objectivefs", "objectivefs"},
+		{"/mnt/volumes/", "", "", "/mnt/volumes", "objectivefs"},
+		{"", "/run/docker/plugins/ofs.sock", "", "/var/lib/docker-volumes/objectivefs", "ofs"},
+		{"", "ofs", "objectivefs", "/var/lib/docker-volumes/objectivefs", "objectivefs"},
+		{"mnt", "", "", "", ""},
+		{"", "bad name", "", "", ""},
+		{"", "", "bad/name", "", ""},
+	}
+	for _, tt := range tests {
+		t.Setenv("OBJECTIVEFS_MOUNT_ROOT", tt.root)
+		t.Setenv("OBJECTIVEFS_SOCKET", tt.socket)
+		t.Setenv("OBJECTIVEFS_DRIVER_NAME", tt.name)
+		c, err := loadConfig()
+		if tt.wantRoot == "" {
+			if err == nil {
+				t.Errorf("root %q, socket %q, name %q accepted", tt.root, tt.socket, tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("root %q, socket %q, name %q: %v", tt.root, tt.socket, tt.name, err)
+		} else if c.mountRoot != tt.wantRoot || c.driverName != tt.wantName {
+			t.Errorf("root %q, socket %q, name %q: root %q, name %q, want %q and %q", tt.root, tt.socket, tt.name, c.mountRoot, c.driverName, tt.wantRoot, tt.wantName)
+		}
+	}
+}
+
+func TestRunDaemonOutput(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		start := time.Now()
+		output, err := run(exec.Command("/bin/sh", "-c", "echo mounted; sleep 5 & echo done >&2"), timeout)
+		if err != nil {
+			t.Errorf("timeout %s: %v", timeout, err)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Errorf("timeout %s: run waited %s for the output of a daemon", timeout, took)
+		}
+		if output != "mounted\ndone\n" {
+			t.Errorf("timeout %s: output %q", timeout, output)
+		}
+	}
+	if output, err := run(exec.Command("/bin/sh", "-c", "echo failed; sleep 5 & exit 3"), 0); err == nil || output != "failed\n" {
+		t.Errorf("failure of a command that left its output open: %q, %v", output, err)
+	}
+	if _, err := run(exec.Command("/bin/sleep", "5"), 100*time.Millisecond); err != errCommandTimeout {
+		t.Errorf("run past timeout: %v", err)
+	}
+}
+
+func TestLoadConfigUsePersist(t *testing.T) {
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	tests := []struct {
+		persist, interval string
+		want              string
+		ok                bool
+	}{
+		{"", "", "interval", true},
+		{"immediate", "", "immediate", true},
+		{"never", "0", "never", true},
+		{"interval", "0", "", false},
+		{"sometimes", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("OBJECTIVEFS_USE_PERSIST", tt.persist)
+		t.Setenv("OBJECTIVEFS_USE_INTERVAL", tt.interval)
+		c, err := loadConfig()
+		if (err == nil) != tt.ok {
+			t.Errorf("OBJECTIVEFS_USE_PERSIST=%q, OBJECTIVEFS_USE_INTERVAL=%q: %v", tt.persist, tt.interval, err)
+		} else if tt.ok && c.usePersist != tt.want {
+			t.Errorf("OBJECTIVEFS_USE_PERSIST=%q: %q, want %q", tt.persist, c.usePersist, tt.want)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	t.Setenv("OBJECTIVEFS_MOUNT_TIMEOUT", "2m")
+	t.Setenv("OBJECTIVEFS_UNMOUNT_TIMEOUT", "30s")
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &ofsDriver{config: c}
+	tests := []struct {
+		opts map[string]string
+		want map[string]time.Duration
+	}{
+		{map[string]string{"fs": "myfs"}, map[string]time.Duration{"mount": 2 * time.Minute, "unmount": 30 * time.Second, "verify": 0}},
+		{map[string]string{"fs": "myfs", "mount_timeout": "10s", "verify_timeout": "5s"}, map[string]time.Duration{"mount": 10 * time.Second, "unmount": 30 * time.Second, "verify": 5 * time.Second}},
+		{map[string]string{"fs": "myfs", "unmount_timeout": "0s"}, map[string]time.Duration{"mount": 2 * time.Minute, "unmount": 0, "verify": 0}},
+	}
+	for _, tt := range tests {
+		v, err := newVolume("vol", tt.opts, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for kind, want := range tt.want {
+			if got := d.timeout(v, kind); got != want {
+				t.Errorf("%v: %s timeout %s, want %s", tt.opts, kind, got, want)
+			}
+		}
+	}
+	for _, val := range []string{"-1s", "10", "soon"} {
+		if _, err := newVolume("vol", map[string]string{"fs": "myfs", "mount_timeout": val}, ""); err == nil {
+			t.Errorf("mount_timeout=%s accepted", val)
+		}
+	}
+	t.Setenv("OBJECTIVEFS_VERIFY_TIMEOUT", "never")
+	if _, err := loadConfig(); err == nil {
+		t.Error("invalid OBJECTIVEFS_VERIFY_TIMEOUT accepted")
+	}
+}
+
+func TestLoadConfigMountpointOwner(t *testing.T) {
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	tests := []struct {
+		mode, owner string
+		want        os.FileMode
+		uid, gid    int
+		ok          bool
+	}{
+		{"", "", 0755, -1, -1, true},
+		{"0750", "1000", 0750, 1000, 1000, true},
+		{"700", "1000:50", 0700, 1000, 50, true},
+		{"0800", "", 0, 0, 0, false},
+		{"1777", "", 0, 0, 0, false},
+		{"", "root", 0, 0, 0, false},
+		{"", "1000:", 0, 0, 0, false},
+		{"", "-1", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("OBJECTIVEFS_MOUNTPOINT_MODE", tt.mode)
+		t.Setenv("OBJECTIVEFS_MOUNTPOINT_OWNER", tt.owner)
+		c, err := loadConfig()
+		if (err == nil) != tt.ok {
+			t.Errorf("mode %q, owner %q: %v, want ok %v", tt.mode, tt.owner, err, tt.ok)
+		} else if tt.ok && (c.mountpointMode != tt.want || c.mountpointUid != tt.uid || c.mountpointGid != tt.gid) {
+			t.Errorf("mode %q, owner %q: %o %d:%d", tt.mode, tt.owner, c.mountpointMode, c.mountpointUid, c.mountpointGid)
+		}
+	}
+}
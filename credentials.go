@@ -0,0 +1,77 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// parseCredentials parses the output of the credentials command, one
+// KEY=VALUE environment variable per line. Empty lines and lines
+// starting with # are skipped.
+func parseCredentials(out []byte) ([]string, error) {
+	var env []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || !envKeyRe.MatchString(kv[0]) {
+			// The line may hold a secret, so only its number is reported.
+			return nil, fmt.Errorf("line %d is not KEY=VALUE", n)
+		}
+		env = append(env, line)
+	}
+	return env, s.Err()
+}
+
+// credentials runs the credentials command for v and returns the
+// environment it printed. Variables v sets itself are left out, so
+// explicit options win.
+func (d *ofsDriver) credentials(v *ofsVolume) ([]string, error) {
+	if len(d.config.credentialsCommand) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.credentialsTimeout)
+	defer cancel()
+	argv := append(d.config.credentialsCommand[1:len(d.config.credentialsCommand):len(d.config.credentialsCommand)], v.volume.Name, v.fs)
+	cmd := exec.CommandContext(ctx, d.config.credentialsCommand[0], argv...)
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("credentials command timed out after %s", d.config.credentialsTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("credentials command failed: %s", err.Error())
+	}
+	env, err := parseCredentials(out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials command output: %s", err.Error())
+	}
+	v.credKeys = make([]string, 0, len(env))
+	var merged []string
+	for _, e := range env {
+		v.credKeys = append(v.credKeys, strings.SplitN(e, "=", 2)[0])
+		if _, ok := envValue(v, strings.SplitN(e, "=", 2)[0]); !ok {
+			merged = append(merged, e)
+		}
+	}
+	return merged, nil
+}
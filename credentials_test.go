@@ -0,0 +1,81 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		out  string
+		want []string
+		ok   bool
+	}{
+		{"AWS_ACCESS_KEY_ID=AKIA\nAWS_SECRET_ACCESS_KEY=s3cr=t\n", []string{"AWS_ACCESS_KEY_ID=AKIA", "AWS_SECRET_ACCESS_KEY=s3cr=t"}, true},
+		{"# from vault\n\n  OBJECTIVEFS_PASSPHRASE=pw  \n", []string{"OBJECTIVEFS_PASSPHRASE=pw"}, true},
+		{"", nil, true},
+		{"KEY=ok\nhunter2\n", nil, false},
+		{"1KEY=x\n", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCredentials([]byte(tt.out))
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCredentials(%q) = %q, %v, want %q", tt.out, got, err, tt.want)
+		}
+		if err != nil && strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("error %q shows the line", err)
+		}
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ok := script("ok", `echo "AWS_ACCESS_KEY_ID=from-$1"; echo "AWS_SECRET_ACCESS_KEY=secret"; echo "FS=$2"`)
+	d := testDriver(t)
+	d.config.credentialsTimeout = time.Second
+	v := testVolume(t, map[string]string{"fs": "s3://b", "AWS_ACCESS_KEY_ID": "explicit"})
+	if env, err := d.credentials(v); err != nil || env != nil {
+		t.Errorf("credentials without a command = %q, %v", env, err)
+	}
+	d.config.credentialsCommand = []string{ok}
+	env, err := d.credentials(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"AWS_SECRET_ACCESS_KEY=secret", "FS=s3://b"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("credentials = %q, want %q", env, want)
+	}
+	if want := []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "FS"}; !reflect.DeepEqual(v.credKeys, want) {
+		t.Errorf("credential keys %q, want %q", v.credKeys, want)
+	}
+	for _, cmd := range []string{script("fail", "echo AWS_SECRET_ACCESS_KEY=secret; exit 1"), script("slow", "exec sleep 5"), script("bad", "echo secret")} {
+		d.config.credentialsCommand = []string{cmd}
+		if _, err := d.credentials(v); err == nil || strings.Contains(err.Error(), "secret") {
+			t.Errorf("credentials from %s: %v", filepath.Base(cmd), err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// volumesFile is the declarative volume list: volume names mapped to the
+// options they are created with, as given to docker volume create -o.
+type volumesFile struct {
+	Volumes map[string]map[string]string `json:"volumes"`
+}
+
+func readVolumesFile(path string) (map[string]map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f volumesFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid volumes file '%s': %s", path, err.Error())
+	}
+	return f.Volumes, nil
+}
+
+// reconcile creates the declared volumes that do not exist yet. Existing
+// volumes are left as they are, even when their options differ. With
+// prune, volumes that are not declared are removed, unless in use.
+func (d *ofsDriver) reconcile() {
+	declared, err := readVolumesFile(d.config.volumesFile)
+	if err != nil {
+		log.Printf("Reconcile: %s", err.Error())
+		return
+	}
+	var names []string
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.RLock()
+		_, ok := d.volumes[name]
+		d.RUnlock()
+		if ok {
+			continue
+		}
+		log.Printf("Reconcile: creating declared ObjectiveFS Volume '%s'", name)
+		if err := d.create(&volume.CreateRequest{Name: name, Options: declared[name]}); err != nil {
+			log.Printf("Reconcile: %s", err.Error())
+		}
+	}
+	if !d.config.volumesPrune {
+		return
+	}
+	for _, v := range d.snapshot() {
+		if _, ok := declared[v.volume.Name]; ok {
+			continue
+		}
+		log.Printf("Reconcile: removing undeclared ObjectiveFS Volume '%s'", v.volume.Name)
+		if err := d.Remove(&volume.RemoveRequest{Name: v.volume.Name}); err != nil {
+			log.Printf("Reconcile: %s", err.Error())
+		}
+	}
+}
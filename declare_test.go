@@ -0,0 +1,77 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+const testVolumesFile = `{"volumes": {
+	"keep": {"fs": "s3://declared"},
+	"new": {"fs": "s3://new", "options": "mt"}
+}}`
+
+func TestReconcile(t *testing.T) {
+	for _, prune := range []bool{false, true} {
+		d := testDriver(t)
+		d.config.volumesFile = filepath.Join(t.TempDir(), "volumes.json")
+		d.config.volumesPrune = prune
+		if err := ioutil.WriteFile(d.config.volumesFile, []byte(testVolumesFile), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"keep", "extra", "busy"} {
+			if err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"fs": "s3://" + name}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		d.volumes["busy"].use["c"] = true
+		d.reconcile()
+		if v := d.volumes["new"]; v == nil || v.fs != "s3://new" || v.opts != "auto,mt" {
+			t.Errorf("prune %v: declared volume not created as declared: %+v", prune, v)
+		}
+		if v := d.volumes["keep"]; v == nil || v.fs != "s3://keep" {
+			t.Errorf("prune %v: existing volume changed: %+v", prune, v)
+		}
+		if _, ok := d.volumes["extra"]; ok == prune {
+			t.Errorf("prune %v: undeclared volume kept %v", prune, ok)
+		}
+		if _, ok := d.volumes["busy"]; !ok {
+			t.Errorf("prune %v: undeclared volume in use removed", prune)
+		}
+	}
+}
+
+func TestReconcileInvalidFile(t *testing.T) {
+	d := testDriver(t)
+	d.config.volumesFile = filepath.Join(t.TempDir(), "volumes.json")
+	d.config.volumesPrune = true
+	if err := d.Create(&volume.CreateRequest{Name: "extra", Options: map[string]string{"fs": "s3://extra"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"", `{"volumes": [`} {
+		if content != "" {
+			if err := ioutil.WriteFile(d.config.volumesFile, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		d.reconcile()
+		if len(d.volumes) != 1 {
+			t.Errorf("volumes file %q: reconciled to %d volumes", content, len(d.volumes))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// endpoint returns the object store and bucket of the filesystem fs, e.g.
+// s3://bucket for s3://bucket/prefix and for bucket.
+func endpoint(fs string) string {
+	bucket := fs
+	if i := strings.Index(fs, "://"); i > 0 {
+		bucket = fs[i+3:]
+	}
+	return scheme(fs) + "://" + strings.SplitN(bucket, "/", 2)[0]
+}
+
+// endpointLimiter limits the number of mounts running at the same time
+// against each endpoint.
+type endpointLimiter struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}
+
+var endpoints = &endpointLimiter{slots: make(map[string]chan struct{})}
+
+// acquire waits for a free mount slot of ep, and returns the function
+// that gives it back. A limit of 0 never waits.
+func (l *endpointLimiter) acquire(ep string, limit int) func() {
+	if limit == 0 {
+		return func() {}
+	}
+	l.Lock()
+	slots, ok := l.slots[ep]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		l.slots[ep] = slots
+	}
+	l.Unlock()
+	select {
+	case slots <- struct{}{}:
+	default:
+		log.Printf("Waiting for a mount slot of '%s'", ep)
+		slots <- struct{}{}
+	}
+	return func() { <-slots }
+}
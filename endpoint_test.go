@@ -0,0 +1,71 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEndpoint(t *testing.T) {
+	for fs, want := range map[string]string{
+		"s3://bucket":         "s3://bucket",
+		"s3://bucket/tenant1": "s3://bucket",
+		"bucket":              "s3://bucket",
+		"bucket/prefix":       "s3://bucket",
+		"gs://bucket/a/b":     "gs://bucket",
+		"http://minio:9000/b": "http://minio:9000",
+	} {
+		if got := endpoint(fs); got != want {
+			t.Errorf("endpoint(%q) = %q, want %q", fs, got, want)
+		}
+	}
+}
+
+func TestEndpointLimiter(t *testing.T) {
+	l := &endpointLimiter{slots: make(map[string]chan struct{})}
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := l.acquire("s3://a", 2)
+			n := atomic.AddInt32(&running, 1)
+			for m := atomic.LoadInt32(&most); n > m && !atomic.CompareAndSwapInt32(&most, m, n); m = atomic.LoadInt32(&most) {
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			release()
+		}()
+	}
+	// Another endpoint has slots of its own, and no limit never waits.
+	done := make(chan bool)
+	go func() {
+		l.acquire("s3://b", 1)()
+		l.acquire("s3://a", 0)()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("mount against another endpoint waited")
+	}
+	wg.Wait()
+	if most != 2 {
+		t.Errorf("%d mounts ran at the same time, want 2", most)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// globalEnvDir holds one file per variable, which ObjectiveFS reads for
+// the variables not set in its environment.
+var globalEnvDir = filepath.Dir(passphraseFile)
+
+// envSources are the sources of the mount environment, lowest precedence
+// first. Within a source the last setting of a variable wins.
+var envSources = []string{"global", "credentials", "profile", "volume"}
+
+type envLayer struct {
+	source  string
+	env     []string
+	options []string
+}
+
+type envSetting struct {
+	Source    string   `json:"source"`
+	Option    string   `json:"option,omitempty"`
+	Value     string   `json:"value"`
+	Overrides []string `json:"overrides,omitempty"`
+}
+
+// resolveEnv returns which layer sets each variable, the later layers
+// overriding the earlier ones. Secret values, and all global variables and
+// credentials, are redacted.
+func resolveEnv(layers []envLayer) map[string]*envSetting {
+	res := make(map[string]*envSetting)
+	for _, l := range layers {
+		for i, e := range l.env {
+			kv := strings.SplitN(e, "=", 2)
+			val := ""
+			if len(kv) == 2 {
+				val = kv[1]
+			}
+			if l.source == "global" || l.source == "credentials" || secretKeyRe.MatchString(kv[0]) {
+				val = "<redacted>"
+			}
+			s := &envSetting{Source: l.source, Value: val}
+			if i < len(l.options) {
+				s.Option = l.options[i]
+			}
+			if prev, ok := res[kv[0]]; ok {
+				s.Overrides = prev.Overrides
+				if prev.Source != l.source {
+					s.Overrides = append(append([]string{}, prev.Overrides...), prev.Source)
+				}
+			}
+			res[kv[0]] = s
+		}
+	}
+	return res
+}
+
+// setEnv adds a variable to the mount environment of v, recording the
+// option that set it.
+func setEnv(v *ofsVolume, key, val, option string) {
+	v.env = append(v.env, key+"="+val)
+	v.envOrigin = append(v.envOrigin, option)
+}
+
+// fromProfile tells if the i-th variable of v was set by its profile
+// rather than by its own options.
+func (v *ofsVolume) fromProfile(i int) bool {
+	_, ok := v.options[v.envOrigin[i]]
+	return !ok
+}
+
+// orderEnv moves the variables set by the profile of v before those of
+// its own options, since the last setting of a variable wins.
+func (v *ofsVolume) orderEnv() {
+	var env, origin []string
+	for _, profile := range []bool{true, false} {
+		for i := range v.env {
+			if v.fromProfile(i) == profile {
+				env, origin = append(env, v.env[i]), append(origin, v.envOrigin[i])
+			}
+		}
+	}
+	v.env, v.envOrigin = env, origin
+}
+
+// globalEnv returns the names of the variables of globalEnvDir. Their
+// values are not read, since the directory also holds the passphrase and
+// license.
+func globalEnv() []string {
+	files, err := ioutil.ReadDir(globalEnvDir)
+	if err != nil {
+		return nil
+	}
+	var env []string
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !envKeyRe.MatchString(f.Name()) {
+			continue
+		}
+		env = append(env, f.Name()+"=")
+	}
+	return env
+}
+
+// envLayers splits the mount environment of v by source. The credentials
+// are those printed at the last mount; only the names of the global
+// variables and credentials are kept.
+func (v *ofsVolume) envLayers() []envLayer {
+	layers := []envLayer{{source: "global", env: globalEnv()}, {source: "credentials"}, {source: "profile"}, {source: "volume"}}
+	for _, key := range v.credKeys {
+		layers[1].env = append(layers[1].env, key+"=")
+	}
+	for i, e := range v.env {
+		l := &layers[3]
+		if v.fromProfile(i) {
+			l = &layers[2]
+		}
+		l.env, l.options = append(l.env, e), append(l.options, v.envOrigin[i])
+	}
+	return layers
+}
+
+func (d *ofsDriver) envResolution(w http.ResponseWriter, name string) {
+	v, err := d.lookup(name)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"Err": err.Error()})
+		return
+	}
+	res := map[string]interface{}{"precedence": envSources, "env": resolveEnv(v.envLayers())}
+	if len(d.config.credentialsCommand) != 0 && v.credKeys == nil {
+		res["credentials"] = "not run yet, shown after the next mount"
+	}
+	v.Unlock()
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveEnv(t *testing.T) {
+	layers := []envLayer{
+		{source: "global", env: []string{"A=", "B=", "OBJECTIVEFS_LICENSE="}},
+		{source: "credentials", env: []string{"B=", "C="}},
+		{source: "profile", env: []string{"A=p", "C=p", "D=p"}, options: []string{"A", "C", "d"}},
+		{source: "volume", env: []string{"A=v", "D=v1", "D=v2", "MY_TOKEN=t"}, options: []string{"A", "D", "d", "MY_TOKEN"}},
+	}
+	want := map[string]*envSetting{
+		"A":                   {Source: "volume", Option: "A", Value: "v", Overrides: []string{"global", "profile"}},
+		"B":                   {Source: "credentials", Value: "<redacted>", Overrides: []string{"global"}},
+		"C":                   {Source: "profile", Option: "C", Value: "p", Overrides: []string{"credentials"}},
+		"D":                   {Source: "volume", Option: "d", Value: "v2", Overrides: []string{"profile"}},
+		"MY_TOKEN":            {Source: "volume", Option: "MY_TOKEN", Value: "<redacted>"},
+		"OBJECTIVEFS_LICENSE": {Source: "global", Value: "<redacted>"},
+	}
+	got := resolveEnv(layers)
+	if len(got) != len(want) {
+		t.Errorf("resolveEnv has %d variables, want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		if !reflect.DeepEqual(got[key], w) {
+			t.Errorf("%s: %+v, want %+v", key, got[key], w)
+		}
+	}
+}
+
+func TestOrderEnv(t *testing.T) {
+	v := &ofsVolume{options: map[string]string{"TZ": "UTC", "locale": "C"}}
+	v.env = []string{"TZ=UTC", "CACHESIZE=2G", "LANG=C", "TZ=Europe/Stockholm"}
+	v.envOrigin = []string{"TZ", "CACHESIZE", "locale", "tz"}
+	v.orderEnv()
+	if want := []string{"CACHESIZE=2G", "TZ=Europe/Stockholm", "TZ=UTC", "LANG=C"}; !reflect.DeepEqual(v.env, want) {
+		t.Errorf("env %q, want %q", v.env, want)
+	}
+	if want := []string{"CACHESIZE", "tz", "TZ", "locale"}; !reflect.DeepEqual(v.envOrigin, want) {
+		t.Errorf("origins %q, want %q", v.envOrigin, want)
+	}
+}
+
+func TestEnvResolution(t *testing.T) {
+	d := testDriver(t)
+	dir := t.TempDir()
+	for name, val := range map[string]string{"CACHESIZE": "500M", "AWS_REGION": "us-east-1", "OBJECTIVEFS_LICENSE": "lic-secret", "bad name": "x"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(val+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldDir, oldProfiles := globalEnvDir, profiles
+	globalEnvDir = dir
+	profiles = &ofsProfiles{sets: map[string]map[string]string{"fast": {"CACHESIZE": "2G", "AWS_REGION": "eu-west-1", "tz": "UTC"}}}
+	t.Cleanup(func() { globalEnvDir, profiles = oldDir, oldProfiles })
+	v, err := newVolume("vol", map[string]string{"fs": "myfs", "profile": "fast", "CACHESIZE": "1G", "TZ": "Europe/Stockholm"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.credKeys = []string{"AWS_REGION", "AWS_ACCESS_KEY_ID"}
+	d.volumes["vol"] = v
+	w := httptest.NewRecorder()
+	d.volumeAction(w, httptest.NewRequest("GET", "/volumes/vol/env-resolution", nil))
+	if strings.Contains(w.Body.String(), "secret") || strings.Contains(w.Body.String(), "500M") {
+		t.Errorf("env-resolution shows a global value: %s", w.Body.String())
+	}
+	var res struct {
+		Precedence []string               `json:"precedence"`
+		Env        map[string]*envSetting `json:"env"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Precedence, envSources) {
+		t.Errorf("precedence %q", res.Precedence)
+	}
+	want := map[string]*envSetting{
+		"CACHESIZE":           {Source: "volume", Option: "CACHESIZE", Value: "1G", Overrides: []string{"global"}},
+		"AWS_REGION":          {Source: "profile", Option: "AWS_REGION", Value: "eu-west-1", Overrides: []string{"global", "credentials"}},
+		"AWS_ACCESS_KEY_ID":   {Source: "credentials", Value: "<redacted>"},
+		"OBJECTIVEFS_LICENSE": {Source: "global", Value: "<redacted>"},
+		"TZ":                  {Source: "volume", Option: "TZ", Value: "Europe/Stockholm", Overrides: []string{"profile"}},
+	}
+	if !reflect.DeepEqual(res.Env, want) {
+		for key, s := range res.Env {
+			t.Errorf("%s: %+v, want %+v", key, s, want[key])
+		}
+	}
+}
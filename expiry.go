@@ -0,0 +1,78 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// expiryVar is the variable holding when temporary credentials expire,
+// as printed by e.g. `aws configure export-credentials --format env`.
+const expiryVar = "AWS_CREDENTIAL_EXPIRATION"
+
+// credentialsExpiry returns when the credentials in env expire, or the
+// zero time if that is not known.
+func credentialsExpiry(env []string) time.Time {
+	for _, e := range env {
+		if strings.HasPrefix(e, expiryVar+"=") {
+			t, _ := time.Parse(time.RFC3339, e[len(expiryVar)+1:])
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// expiryAlert tells which alert is due for credentials expiring at expiry:
+// "credentials_expired", "credentials_expiring" within the warning window,
+// or none.
+func expiryAlert(expiry time.Time, window time.Duration, now time.Time) string {
+	switch {
+	case expiry.IsZero() || window == 0:
+		return ""
+	case !now.Before(expiry):
+		return "credentials_expired"
+	case expiry.Sub(now) <= window:
+		return "credentials_expiring"
+	}
+	return ""
+}
+
+// setExpiry records the expiry of the credentials v was mounted with.
+func (d *ofsDriver) setExpiry(v *ofsVolume, env []string) {
+	v.credsExpiry, v.expiryAlert = credentialsExpiry(env), ""
+	if v.credsExpiry.IsZero() {
+		metrics.unset("objectivefs_credentials_expiry_timestamp_seconds", "volume", v.volume.Name)
+		return
+	}
+	metrics.set("objectivefs_credentials_expiry_timestamp_seconds", float64(v.credsExpiry.Unix()), "volume", v.volume.Name)
+}
+
+// checkExpiry warns once when the credentials of the mounted v come within
+// the warning window of their expiry, and once more when they expire.
+func (d *ofsDriver) checkExpiry(v *ofsVolume, now time.Time) {
+	alert := expiryAlert(v.credsExpiry, d.config.expiryWarning, now)
+	if alert == "" || alert == v.expiryAlert {
+		return
+	}
+	v.expiryAlert = alert
+	expires := v.credsExpiry.Format(time.RFC3339)
+	if alert == "credentials_expired" {
+		log.Printf("Warning: credentials of ObjectiveFS Volume '%s' expired at %s", v.volume.Name, expires)
+	} else {
+		log.Printf("Warning: credentials of ObjectiveFS Volume '%s' expire at %s", v.volume.Name, expires)
+	}
+	d.notify(alert, v.volume.Name, "expires", expires)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCredentialsExpiry(t *testing.T) {
+	tests := []struct {
+		env  []string
+		want time.Time
+	}{
+		{[]string{"AWS_ACCESS_KEY_ID=AKIA", "AWS_CREDENTIAL_EXPIRATION=2024-05-01T12:00:00Z"}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{[]string{"AWS_CREDENTIAL_EXPIRATION=2024-05-01T14:00:00+02:00"}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{[]string{"AWS_CREDENTIAL_EXPIRATION=tomorrow"}, time.Time{}},
+		{[]string{"AWS_CREDENTIAL_EXPIRATION_X=2024-05-01T12:00:00Z"}, time.Time{}},
+		{nil, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := credentialsExpiry(tt.env); !got.Equal(tt.want) {
+			t.Errorf("credentialsExpiry(%q) = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestExpiryAlert(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expiry time.Time
+		window time.Duration
+		want   string
+	}{
+		{time.Time{}, time.Hour, ""},
+		{now.Add(time.Minute), 0, ""},
+		{now.Add(2 * time.Hour), time.Hour, ""},
+		{now.Add(time.Hour), time.Hour, "credentials_expiring"},
+		{now.Add(time.Second), time.Hour, "credentials_expiring"},
+		{now, time.Hour, "credentials_expired"},
+		{now.Add(-time.Hour), time.Hour, "credentials_expired"},
+	}
+	for _, tt := range tests {
+		if got := expiryAlert(tt.expiry, tt.window, now); got != tt.want {
+			t.Errorf("expiryAlert(%v, %v) = %q, want %q", tt.expiry, tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	d := testDriver(t)
+	d.config.expiryWarning = time.Hour
+	events := webhook(t, d)
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	expiry := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	d.setExpiry(v, []string{"AWS_CREDENTIAL_EXPIRATION=" + expiry.Format(time.RFC3339)})
+	for _, now := range []time.Time{expiry.Add(-2 * time.Hour), expiry.Add(-time.Hour), expiry.Add(-time.Minute), expiry, expiry.Add(time.Hour)} {
+		d.checkExpiry(v, now)
+	}
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if e["volume"] != "vol" || e["expires"] != "2024-05-01T12:00:00Z" {
+				t.Errorf("event %v", e)
+			}
+			got[e["event"]] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events %v, want credentials_expiring and credentials_expired", got)
+		}
+	}
+	if !got["credentials_expiring"] || !got["credentials_expired"] {
+		t.Errorf("events %v, want credentials_expiring and credentials_expired", got)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("extra event %v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+	d.setExpiry(v, nil)
+	if !v.credsExpiry.IsZero() || v.expiryAlert != "" {
+		t.Errorf("expiry %v, alert %q after remount without expiry", v.credsExpiry, v.expiryAlert)
+	}
+}
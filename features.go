@@ -0,0 +1,53 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// createOptions are the volume create options handled by parseOption.
+// Any other option is passed to the helper as an environment variable.
+var createOptions = []string{
+	"fs", "options", "options.<group>", "asap", "key_version", "latency_probe",
+	"mount_on_create", "max_mount_age", "attr_timeout", "entry_timeout",
+	"mount_timeout", "unmount_timeout", "verify_timeout", "verify_read", "verify_read_path",
+	"cache_device", "prefix", "nice", "tz", "locale", "dns", "profile", "on_unhealthy",
+	"tag.<key>", "cost_tag", "propagation",
+}
+
+// volumeActions are the POST /volumes/<name>/<action> admin endpoints.
+var volumeActions = []string{"reset", "sync", "warm"}
+
+func (d *ofsDriver) features(w http.ResponseWriter, r *http.Request) {
+	var policies []string
+	for p := range umountSteps {
+		policies = append(policies, p)
+	}
+	sort.Strings(policies)
+	endpoints := []string{"GET /features", "GET /health", "GET /metrics", "GET /mode", "POST /mode", "POST /reconcile", "GET /resources", "GET /stale-mounts", "GET /stats", "POST /unmount-all"}
+	endpoints = append(endpoints, "GET /volumes/<name>/env-resolution", "GET /volumes/<name>/logs", "GET /volumes/<name>/remove-plan")
+	for _, a := range volumeActions {
+		endpoints = append(endpoints, "POST /volumes/<name>/"+a)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version":          version,
+		"options":          createOptions,
+		"endpoints":        endpoints,
+		"mount_styles":     []string{"helper", "subcommand"},
+		"unmount_policies": policies,
+		"scope":            d.Capabilities().Capabilities.Scope,
+	})
+}
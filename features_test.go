@@ -0,0 +1,188 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// handledOptions returns the option names parseOption switches on, and the
+// key prefixes it checks for, which are listed as <prefix><placeholder>.
+func handledOptions(t *testing.T) (names, prefixes []string) {
+	f, err := parser.ParseFile(token.NewFileSet(), "options.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "parseOption" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CaseClause:
+				for _, e := range n.List {
+					if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						s, _ := strconv.Unquote(lit.Value)
+						names = append(names, s)
+					}
+				}
+			case *ast.CallExpr:
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "HasPrefix" && len(n.Args) == 2 && isIdent(n.Args[0], "key") {
+					if lit, ok := n.Args[1].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						s, _ := strconv.Unquote(lit.Value)
+						prefixes = append(prefixes, s)
+					}
+				}
+			}
+			return true
+		})
+	}
+	if len(names) == 0 {
+		t.Fatal("no options found in parseOption")
+	}
+	return names, prefixes
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+func TestCreateOptions(t *testing.T) {
+	listed := make(map[string]bool)
+	for _, o := range createOptions {
+		listed[o] = true
+	}
+	// ptions is a misspelling of options kept for old volumes.
+	handled := map[string]bool{"ptions": true, "profile": true}
+	names, prefixes := handledOptions(t)
+	for _, name := range names {
+		handled[name] = true
+		if !listed[name] && name != "ptions" {
+			t.Errorf("option %q handled by parseOption but not in createOptions", name)
+		}
+	}
+	for _, prefix := range prefixes {
+		found := false
+		for o := range listed {
+			if len(o) > len(prefix) && o[:len(prefix)] == prefix && o[len(prefix)] == '<' {
+				found, handled[o] = true, true
+			}
+		}
+		if !found {
+			t.Errorf("option prefix %q handled by parseOption but not in createOptions", prefix)
+		}
+	}
+	for _, o := range createOptions {
+		if !handled[o] {
+			t.Errorf("option %q in createOptions is not handled by parseOption", o)
+		}
+	}
+}
+
+// handledEndpoints returns the paths registered with HandleFunc in main,
+// and the "<METHOD> /volumes/<name>/<action>" endpoints volumeAction
+// switches on.
+func handledEndpoints(t *testing.T) (paths, actions []string) {
+	fset := token.NewFileSet()
+	for _, file := range []string{"main.go", "admin.go"} {
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "HandleFunc" && isIdent(sel.X, "h") && len(n.Args) == 2 {
+					if lit, ok := n.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						s, _ := strconv.Unquote(lit.Value)
+						paths = append(paths, s)
+					}
+				}
+			case *ast.BinaryExpr:
+				if n.Op != token.LAND {
+					return true
+				}
+				a, ok1 := n.X.(*ast.BinaryExpr)
+				m, ok2 := n.Y.(*ast.BinaryExpr)
+				if !ok1 || !ok2 || !isIdent(a.X, "action") {
+					return true
+				}
+				lit, ok1 := a.Y.(*ast.BasicLit)
+				method, ok2 := m.Y.(*ast.SelectorExpr)
+				if ok1 && ok2 && strings.HasPrefix(method.Sel.Name, "Method") {
+					s, _ := strconv.Unquote(lit.Value)
+					actions = append(actions, strings.ToUpper(strings.TrimPrefix(method.Sel.Name, "Method"))+" /volumes/<name>/"+s)
+				}
+			}
+			return true
+		})
+	}
+	if len(paths) == 0 || len(actions) == 0 {
+		t.Fatal("no endpoints found in main and volumeAction")
+	}
+	return paths, actions
+}
+
+func TestEndpoints(t *testing.T) {
+	d := testDriver(t)
+	w := httptest.NewRecorder()
+	d.features(w, httptest.NewRequest("GET", "/features", nil))
+	var res struct {
+		Endpoints []string `json:"endpoints"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	listedPaths := make(map[string]bool)
+	var listedActions []string
+	for _, e := range res.Endpoints {
+		path := e[strings.Index(e, " ")+1:]
+		if strings.HasPrefix(path, "/volumes/") {
+			listedActions = append(listedActions, e)
+		} else {
+			listedPaths[path] = true
+		}
+	}
+	paths, actions := handledEndpoints(t)
+	handledPaths := make(map[string]bool)
+	for _, p := range paths {
+		if p == "/volumes/" {
+			continue
+		}
+		handledPaths[p] = true
+		if !listedPaths[p] {
+			t.Errorf("endpoint %q registered but not listed by /features", p)
+		}
+	}
+	for p := range listedPaths {
+		if !handledPaths[p] {
+			t.Errorf("endpoint %q listed by /features but not registered", p)
+		}
+	}
+	sort.Strings(actions)
+	sort.Strings(listedActions)
+	if strings.Join(actions, "\n") != strings.Join(listedActions, "\n") {
+		t.Errorf("volume endpoints listed by /features %q, handled by volumeAction %q", listedActions, actions)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// checkHooks refuses hook options unless hooks are enabled, since they
+// run commands as root on behalf of whoever may create volumes.
+func (d *ofsDriver) checkHooks(v *ofsVolume) error {
+	if v.onUnhealthy != "" && !d.config.allowHooks {
+		return fmt.Errorf("invalid options for volume '%s': on_unhealthy: hooks are disabled, set OBJECTIVEFS_ALLOW_HOOKS=true", v.volume.Name)
+	}
+	return nil
+}
+
+// setHealth records the health of v found by the checker. The first time
+// v is found unhealthy its on_unhealthy hook runs, with the volume name
+// and reason as arguments; it runs again only after v was healthy.
+func (d *ofsDriver) setHealth(v *ofsVolume, reason string) {
+	if reason == "" {
+		v.unhealthy = false
+		return
+	}
+	if v.unhealthy {
+		return
+	}
+	v.unhealthy = true
+	log.Printf("ObjectiveFS Volume '%s' unhealthy: %s", v.volume.Name, reason)
+	if v.onUnhealthy == "" || !d.config.allowHooks {
+		return
+	}
+	argv := append(strings.Fields(v.onUnhealthy), v.volume.Name, reason)
+	name := v.volume.Name
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), d.config.hookTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", d.config.hookTimeout)
+		}
+		if err != nil {
+			log.Printf("on_unhealthy hook of ObjectiveFS Volume '%s' failed: %s: %s", name, err.Error(), strings.TrimSpace(string(out)))
+			return
+		}
+		log.Printf("on_unhealthy hook of ObjectiveFS Volume '%s' done", name)
+	}()
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHooks(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs", "on_unhealthy": "/bin/true"})
+	if err := d.checkHooks(v); err == nil {
+		t.Error("on_unhealthy accepted with hooks disabled")
+	}
+	d.config.allowHooks = true
+	if err := d.checkHooks(v); err != nil {
+		t.Errorf("on_unhealthy with hooks enabled: %v", err)
+	}
+	d.config.allowHooks = false
+	if err := d.checkHooks(testVolume(t, map[string]string{"fs": "myfs"})); err != nil {
+		t.Errorf("no hook with hooks disabled: %v", err)
+	}
+}
+
+func TestSetHealth(t *testing.T) {
+	dir := t.TempDir()
+	hook, calls := filepath.Join(dir, "hook"), filepath.Join(dir, "calls")
+	if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d := testDriver(t)
+	d.config.allowHooks, d.config.hookTimeout = true, time.Second
+	v := testVolume(t, map[string]string{"fs": "myfs", "on_unhealthy": hook + " --page"})
+	for _, reason := range []string{"io errors", "io errors", "", "", "not connected", "io errors"} {
+		d.setHealth(v, reason)
+		if v.unhealthy != (reason != "") {
+			t.Errorf("setHealth(%q): unhealthy %v", reason, v.unhealthy)
+		}
+		// The hook runs in the background.
+		time.Sleep(100 * time.Millisecond)
+	}
+	want := []string{"--page vol io errors", "--page vol not connected"}
+	var got []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b, _ := ioutil.ReadFile(calls)
+		if got = strings.Split(strings.TrimSpace(string(b)), "\n"); len(got) >= len(want) {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hook ran with %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// idleExpired tells if a volume created at created should be removed for
+// never having been used within age.
+func idleExpired(created string, used bool, age time.Duration, now time.Time) bool {
+	if used {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, created)
+	return err == nil && now.Sub(t) >= age
+}
+
+// removeIdle removes the definitions of the volumes that were never
+// mounted since they were created longer than OBJECTIVEFS_IDLE_REMOVE_AGE
+// ago. Their filesystems are not touched.
+func (d *ofsDriver) removeIdle(now time.Time) {
+	for _, v := range d.snapshot() {
+		d.use.Lock()
+		used := d.use.used[v.volume.Name]
+		d.use.Unlock()
+		v.Lock()
+		expired := !v.removed && !v.mounted && len(v.use) == 0 && idleExpired(v.volume.CreatedAt, used, d.config.idleRemoveAge, now)
+		name := v.volume.Name
+		v.Unlock()
+		if !expired {
+			continue
+		}
+		log.Printf("Remove ObjectiveFS Volume '%s', never mounted in %s", name, d.config.idleRemoveAge)
+		if err := d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+			log.Printf("Idle remove: %s", err.Error())
+		}
+	}
+}
+
+func (d *ofsDriver) idleLoop() {
+	interval := d.config.idleRemoveAge / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	} else if interval > time.Hour {
+		interval = time.Hour
+	}
+	for now := range time.Tick(interval) {
+		d.removeIdle(now)
+	}
+}
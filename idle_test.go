@@ -0,0 +1,69 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleExpired(t *testing.T) {
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		created string
+		used    bool
+		want    bool
+	}{
+		{"2020-01-01T00:00:00Z", false, true},
+		{"2020-01-01T00:00:00.5Z", false, false},
+		{"2019-12-01T00:00:00Z", true, false},
+		{"2020-01-01T12:00:00Z", false, false},
+		{"", false, false},
+		{"yesterday", false, false},
+	}
+	for _, tt := range tests {
+		if got := idleExpired(tt.created, tt.used, 24*time.Hour, now); got != tt.want {
+			t.Errorf("idleExpired(%q, %v) = %v, want %v", tt.created, tt.used, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveIdle(t *testing.T) {
+	d := testDriver(t)
+	d.config.idleRemoveAge = time.Hour
+	old := time.Now().Add(-2 * time.Hour).Format(time.RFC3339Nano)
+	for _, name := range []string{"idle", "mounted", "inuse", "used", "recent"} {
+		created := old
+		if name == "recent" {
+			created = time.Now().Format(time.RFC3339Nano)
+		}
+		v, err := newVolume(name, map[string]string{"fs": "myfs"}, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.volumes[name] = v
+	}
+	d.volumes["mounted"].mounted = true
+	d.volumes["inuse"].use["c1"] = true
+	d.use.used["used"] = true
+	d.removeIdle(time.Now())
+	if _, ok := d.volumes["idle"]; ok {
+		t.Errorf("idle volume not removed")
+	}
+	for _, name := range []string{"mounted", "inuse", "used", "recent"} {
+		if _, ok := d.volumes[name]; !ok {
+			t.Errorf("volume %s removed", name)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseIO returns the bytes read and written through system calls from a
+// /proc/<pid>/io.
+func parseIO(data []byte) (read, written uint64, ok bool) {
+	var found int
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "rchar:":
+			read, found = n, found+1
+		case "wchar:":
+			written, found = n, found+1
+		}
+	}
+	return read, written, found == 2
+}
+
+// mountedDir returns the directory below the plugin mount root that the
+// ObjectiveFS process with cmdline serves.
+func mountedDir(cmdline []byte) string {
+	if !isMountProcess(cmdline) {
+		return ""
+	}
+	args := strings.Split(string(cmdline), "\x00")
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, mountRoot+"/") {
+			return filepath.Clean(arg)
+		}
+	}
+	return ""
+}
+
+// volumeOf returns the name of the volume dir is the mountpoint or a
+// layer directory of.
+func volumeOf(dir string) string {
+	if rel, err := filepath.Rel(layerRoot(), dir); err == nil && !strings.HasPrefix(rel, "..") {
+		return strings.SplitN(rel, "/", 2)[0]
+	}
+	if filepath.Dir(dir) == mountRoot {
+		return filepath.Base(dir)
+	}
+	return ""
+}
+
+type ioSample struct {
+	at          time.Time
+	read        uint64
+	written     uint64
+	readRate    float64
+	writtenRate float64
+}
+
+// ofsIOStats holds the last IO sample of the ObjectiveFS processes of each
+// volume.
+type ofsIOStats struct {
+	sync.Mutex
+	samples map[string]ioSample
+}
+
+var ioStats = &ofsIOStats{samples: make(map[string]ioSample)}
+
+// collectIO sums the IO counters of the ObjectiveFS processes per volume.
+func collectIO() map[string][2]uint64 {
+	totals := make(map[string][2]uint64)
+	dirs, _ := filepath.Glob(filepath.Join(procRoot, "[0-9]*"))
+	for _, dir := range dirs {
+		cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			continue
+		}
+		name := volumeOf(mountedDir(cmdline))
+		if name == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "io"))
+		if err != nil {
+			continue
+		}
+		if r, w, ok := parseIO(data); ok {
+			t := totals[name]
+			totals[name] = [2]uint64{t[0] + r, t[1] + w}
+		}
+	}
+	return totals
+}
+
+// update records a new sample for every volume, with the rates since the
+// previous one. Volumes without a process are dropped.
+func (s *ofsIOStats) update(totals map[string][2]uint64, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	for name := range s.samples {
+		if _, ok := totals[name]; !ok {
+			delete(s.samples, name)
+			metrics.unset("objectivefs_process_read_bytes", "volume", name)
+			metrics.unset("objectivefs_process_written_bytes", "volume", name)
+		}
+	}
+	for name, t := range totals {
+		cur := ioSample{at: now, read: t[0], written: t[1]}
+		// Counters start over when the process is restarted by a remount.
+		if prev, ok := s.samples[name]; ok && cur.read >= prev.read && cur.written >= prev.written {
+			secs := now.Sub(prev.at).Seconds()
+			cur.readRate = float64(cur.read-prev.read) / secs
+			cur.writtenRate = float64(cur.written-prev.written) / secs
+		}
+		s.samples[name] = cur
+		metrics.set("objectivefs_process_read_bytes", float64(cur.read), "volume", name)
+		metrics.set("objectivefs_process_written_bytes", float64(cur.written), "volume", name)
+	}
+}
+
+func (s *ofsIOStats) status(name string) map[string]interface{} {
+	s.Lock()
+	defer s.Unlock()
+	cur, ok := s.samples[name]
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"read_bytes": cur.read, "written_bytes": cur.written,
+		"read_bytes_per_second": cur.readRate, "written_bytes_per_second": cur.writtenRate,
+	}
+}
+
+func (d *ofsDriver) ioLoop() {
+	for now := range time.Tick(d.config.ioStatsInterval) {
+		ioStats.update(collectIO(), now)
+	}
+}
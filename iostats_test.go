@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseIO(t *testing.T) {
+	tests := []struct {
+		data          string
+		read, written uint64
+		ok            bool
+	}{
+		{"rchar: 1000\nwchar: 200\nsyscr: 5\nread_bytes: 4096\n", 1000, 200, true},
+		{"wchar: 0\nrchar: 18446744073709551615\n", 18446744073709551615, 0, true},
+		{"rchar: 1000\n", 1000, 0, false},
+		{"rchar: x\nwchar: 2\n", 0, 2, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		r, w, ok := parseIO([]byte(tt.data))
+		if r != tt.read || w != tt.written || ok != tt.ok {
+			t.Errorf("parseIO(%q) = %d, %d, %v, want %d, %d, %v", tt.data, r, w, ok, tt.read, tt.written, tt.ok)
+		}
+	}
+}
+
+func TestVolumeOf(t *testing.T) {
+	testDriver(t)
+	for dir, want := range map[string]string{
+		filepath.Join(mountRoot, "vol"):        "vol",
+		filepath.Join(layerRoot(), "vol", "1"): "vol",
+		filepath.Join(mountRoot, "vol", "sub"): "",
+		"/mnt/vol":                             "",
+		"":                                     "",
+	} {
+		if got := volumeOf(dir); got != want {
+			t.Errorf("volumeOf(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestCollectIO(t *testing.T) {
+	testDriver(t)
+	root := t.TempDir()
+	proc := func(pid, cmdline, io string) {
+		dir := filepath.Join(root, pid)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "io"), []byte(io), 0644)
+	}
+	proc("10", "/sbin/mount.objectivefs\x00s3://a\x00"+filepath.Join(mountRoot, "a")+"\x00", "rchar: 100\nwchar: 10\n")
+	proc("11", "/sbin/mount.objectivefs\x00s3://b0\x00"+filepath.Join(layerRoot(), "b", "0")+"\x00", "rchar: 200\nwchar: 20\n")
+	proc("12", "/sbin/mount.objectivefs\x00s3://b1\x00"+filepath.Join(layerRoot(), "b", "1")+"\x00", "rchar: 300\nwchar: 30\n")
+	proc("13", "/bin/sh\x00", "rchar: 1\nwchar: 1\n")
+	old := procRoot
+	procRoot = root
+	defer func() { procRoot = old }()
+	want := map[string][2]uint64{"a": {100, 10}, "b": {500, 50}}
+	if got := collectIO(); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectIO = %v, want %v", got, want)
+	}
+}
+
+func TestIOStatsUpdate(t *testing.T) {
+	s := &ofsIOStats{samples: make(map[string]ioSample)}
+	now := time.Now()
+	s.update(map[string][2]uint64{"a": {100, 10}, "b": {5, 5}}, now)
+	if st := s.status("a"); st["read_bytes_per_second"] != 0.0 {
+		t.Errorf("first sample %v", st)
+	}
+	s.update(map[string][2]uint64{"a": {300, 30}}, now.Add(10*time.Second))
+	st := s.status("a")
+	if st["read_bytes"] != uint64(300) || st["read_bytes_per_second"] != 20.0 || st["written_bytes_per_second"] != 2.0 {
+		t.Errorf("second sample %v", st)
+	}
+	if s.status("b") != nil {
+		t.Error("volume without a process kept")
+	}
+	// A restarted process starts its counters over.
+	s.update(map[string][2]uint64{"a": {50, 5}}, now.Add(20*time.Second))
+	if st := s.status("a"); st["read_bytes"] != uint64(50) || st["read_bytes_per_second"] != 0.0 {
+		t.Errorf("sample after restart %v", st)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A volume with several filesystems in fs mounts each of them below
+// layerRoot and exposes them as one read-only overlay at its mountpoint.
+// The first filesystem listed is the top layer.
+func layerRoot() string {
+	return filepath.Join(mountRoot, ".layers")
+}
+
+func validLayers(layers []string) error {
+	seen := make(map[string]bool)
+	for _, fs := range layers {
+		if fs == "" {
+			return fmt.Errorf("empty filesystem name in fs list")
+		}
+		if seen[fs] {
+			return fmt.Errorf("filesystem '%s' listed more than once", fs)
+		}
+		seen[fs] = true
+	}
+	return nil
+}
+
+func layerDir(v *ofsVolume, i int) string {
+	return filepath.Join(layerRoot(), v.volume.Name, strconv.Itoa(i))
+}
+
+func (d *ofsDriver) mountLayers(v *ofsVolume) error {
+	var dirs []string
+	for i, fs := range v.layers {
+		dir := layerDir(v, i)
+		if err := d.checkSymlink(dir); err != nil {
+			d.umountLayers(v, i, umountNormal)
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			d.umountLayers(v, i, umountNormal)
+			return err
+		}
+		if err := d.mountFs(v, fs, dir); err != nil {
+			os.Remove(dir)
+			d.umountLayers(v, i, umountNormal)
+			return err
+		}
+		dirs = append(dirs, dir)
+	}
+	cmd := d.config.command("mount", "-t", "overlay", "overlay", "-o", "lowerdir="+strings.Join(dirs, ":"), v.volume.Mountpoint)
+	log.Printf("Mount ObjectiveFS Volume '%s': '%s'", v.volume.Name, cmd)
+	if out, err := run(cmd, d.timeout(v, "mount")); err != nil {
+		if err == errCommandTimeout {
+			out = fmt.Sprintf("timed out after %s", d.timeout(v, "mount"))
+		}
+		d.umountLayers(v, len(v.layers), umountNormal)
+		return fmt.Errorf("unexpected error merging layers of '%s': %s", v.volume.Name, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// umountLayers unmounts the first n layers of v, top layer last.
+func (d *ofsDriver) umountLayers(v *ofsVolume, n int, policy string) error {
+	var failed error
+	for i := n - 1; i >= 0; i-- {
+		dir := layerDir(v, i)
+		if err := d.umountDir(dir, policy, d.timeout(v, "unmount")); err != nil {
+			log.Printf("Unmount layer '%s' of ObjectiveFS Volume '%s' failed: %s", dir, v.volume.Name, err.Error())
+			failed = err
+			continue
+		}
+		os.Remove(dir)
+	}
+	if failed == nil {
+		os.Remove(filepath.Join(layerRoot(), v.volume.Name))
+	}
+	return failed
+}
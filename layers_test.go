@@ -0,0 +1,75 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestValidLayers(t *testing.T) {
+	tests := []struct {
+		layers []string
+		ok     bool
+	}{
+		{[]string{"a", "b"}, true},
+		{[]string{"a", "b", "c"}, true},
+		{[]string{"a", ""}, false},
+		{[]string{"a", "b", "a"}, false},
+	}
+	for _, tt := range tests {
+		if err := validLayers(tt.layers); (err == nil) != tt.ok {
+			t.Errorf("validLayers(%q) = %v, want ok %v", tt.layers, err, tt.ok)
+		}
+	}
+}
+
+func TestLayerOptions(t *testing.T) {
+	testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "s3://top,s3://base"})
+	if want := []string{"s3://top", "s3://base"}; !reflect.DeepEqual(v.layers, want) {
+		t.Errorf("layers %q, want %q", v.layers, want)
+	}
+	if got, want := layerDir(v, 1), filepath.Join(mountRoot, ".layers", "vol", "1"); got != want {
+		t.Errorf("layerDir = %q, want %q", got, want)
+	}
+	if _, err := newVolume("vol", map[string]string{"fs": "s3://a,,s3://b"}, ""); err == nil {
+		t.Error("empty layer accepted")
+	}
+}
+
+func TestMountLayersTimeout(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "s3://top,s3://base", "mount_timeout": "200ms"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.fail(t, "mount-hang", "hang")
+	start := time.Now()
+	_, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("hung overlay mount: %v", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("hung overlay mount took %s", took)
+	}
+	if f.mounts() != 0 {
+		t.Errorf("%d mounts left after the overlay failed", f.mounts())
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"regexp"
+)
+
+// ObjectiveFS checks its license with a license server when mounting. An
+// unreachable server is worth retrying, a rejected license is not. A
+// failure to talk to the server is checked first, since its message may
+// go on to describe the bad response, as in "license server unreachable:
+// invalid response"; a rejection must say the license itself is invalid.
+var (
+	licenseTransientRe = regexp.MustCompile(`(?i)license (server|check)\b.*\b(timed? ?out|timeout|connect|unreachable|unavailable|temporar|resolve|network|try again|refused)|\b(connect(ing|ion)? to|reach|resolve) (the )?license server`)
+	licenseInvalidRe   = regexp.MustCompile(`(?i)\blicense( key)?:? ((is|has been|was) )?(invalid|expired|revoked|not valid|disabled|exceeded)\b|\b(invalid|expired|revoked|unknown|no) license\b`)
+)
+
+// Kinds of license errors.
+const (
+	licenseTransient = "transient"
+	licenseInvalid   = "invalid"
+)
+
+// licenseError classifies the license error in the output of a failed
+// mount, returning "" when the failure is not about the license.
+func licenseError(output string) string {
+	switch {
+	case licenseTransientRe.MatchString(output):
+		return licenseTransient
+	case licenseInvalidRe.MatchString(output):
+		return licenseInvalid
+	}
+	return ""
+}
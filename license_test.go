@@ -0,0 +1,48 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import "testing"
+
+func TestLicenseError(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"license server unreachable: invalid response", licenseTransient},
+		{"License server timed out", licenseTransient},
+		{"license check failed: connection refused", licenseTransient},
+		{"license server temporarily unavailable, try again", licenseTransient},
+		{"cannot resolve license server license.objectivefs.com", licenseTransient},
+		{"error connecting to license server", licenseTransient},
+		{"license is invalid", licenseInvalid},
+		{"License has been revoked", licenseInvalid},
+		{"license expired on 2024-01-01", licenseInvalid},
+		{"license: exceeded", licenseInvalid},
+		{"Invalid license", licenseInvalid},
+		{"no license found, set OBJECTIVEFS_LICENSE", licenseInvalid},
+		{"license key not valid", licenseInvalid},
+		{"unknown license", licenseInvalid},
+		{"invalid credentials", ""},
+		{"bucket not found", ""},
+		{"network unreachable", ""},
+		{"license accepted; bucket access timed out", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := licenseError(tt.output); got != tt.want {
+			t.Errorf("licenseError(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
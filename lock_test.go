@@ -0,0 +1,53 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockPid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "objectivefs.pid")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("99999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := lockPid(path)
+	if err != nil {
+		t.Fatalf("taking over stale lock: %v", err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if got := strings.TrimSpace(string(b)); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want our pid", got)
+	}
+	if _, err := lockPid(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("second lock: %v, want already running", err)
+	}
+	l.release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file left after release: %v", err)
+	}
+	l, err = lockPid(path)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	l.release()
+}
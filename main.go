@@ -18,180 +18,879 @@ import (
 	"github.com/docker/go-plugins-helpers/volume"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 type ofsVolume struct {
+	sync.Mutex
 	volume  *volume.Volume
+	options map[string]string
 	fs      string
+	layers  []string
 	opts    string
+	groups  map[string]string
+	tags    map[string]string
 	env     []string
 	use     map[string]bool
 	mounted bool
 	asap    bool
+
+	keyVersion string
+	probe      bool
+	firstByte  time.Duration
+
+	mountOnCreate bool
+	fstype        string
+	failures      []time.Time
+	removed       bool
+	cacheDevice   string
+	cacheDir      string
+	crashes       []time.Time
+	trippedAt     time.Time
+	prefix        string
+	nice          *int
+	warnings      []string
+	maxAge        time.Duration
+	mountedAt     time.Time
+	rotationDue   bool
+	warm          *warmProgress
+	fuseTimeouts  map[string]string
+	timeouts      map[string]time.Duration
+	verifyRead    bool
+	verifyPath    string
+	profile       string
+	ioErrors      []time.Time
+	onUnhealthy   string
+	dns           []string
+	unhealthy     bool
+	credsExpiry   time.Time
+	expiryAlert   string
+	propagation   string
+	envOrigin     []string
+	credKeys      []string
 }
 
 type ofsDriver struct {
 	sync.RWMutex
+	config  ofsConfig
 	volumes map[string]*ofsVolume
+	use     useState
+	mode    string
+	// unrestored are the saved volumes that failed to restore, e.g. for
+	// a missing profile, kept in the state file for the next start.
+	unrestored []stateVolume
 }
 
 var version = "1.0"
 
-func (d ofsDriver) Create(r *volume.CreateRequest) error {
-	log.Printf("Create ObjectiveFS Volume '%s'", r.Name)
-	d.Lock()
-	defer d.Unlock()
+var startTime = time.Now()
 
-	if _, ok := d.volumes[r.Name]; ok {
-		return fmt.Errorf("volume '%s' already exists", r.Name)
+// volumeNameRe is the volume name format of Docker. Names are used as
+// mountpoint directory names unchanged, so this keeps every name on its
+// own directory, apart from the .layers directory.
+var volumeNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// mountRoot is the directory volumes are mounted in, set from the
+// configuration at startup.
+var mountRoot = filepath.Join(volume.DefaultDockerRootDirectory, "objectivefs")
+
+// maxOptionArg is the longest -o argument passed to the mount helper. The
+// options end up in the FUSE mount data, which is limited to a page.
+const maxOptionArg = 4000
+
+// maxOptionsTotal limits the length of all -o arguments together, well
+// below the argument size limit of exec.
+const maxOptionsTotal = 64 * 1024
+
+// splitOptions splits a comma separated option list into lists of at most
+// max bytes each.
+func splitOptions(opts string, max int) ([]string, error) {
+	var args []string
+	cur := ""
+	for _, o := range strings.Split(opts, ",") {
+		if len(o) > max {
+			return nil, fmt.Errorf("mount option '%.32s...' is %d bytes, longer than the %d the mount helper accepts", o, len(o), max)
+		}
+		if cur != "" && len(cur)+1+len(o) > max {
+			args = append(args, cur)
+			cur = ""
+		}
+		if cur != "" {
+			cur += ","
+		}
+		cur += o
 	}
+	return append(args, cur), nil
+}
+
+// optionArgs returns the -o arguments of v, splitting option lists too
+// long for a single argument.
+func (v *ofsVolume) optionArgs() ([]string, error) {
+	lists := []string{v.opts}
+	var groups []string
+	for g := range v.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		lists = append(lists, v.groups[g])
+	}
+	var args []string
+	total := 0
+	for _, l := range lists {
+		split, err := splitOptions(l, maxOptionArg)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range split {
+			args = append(args, "-o"+o)
+			total += len(o)
+		}
+	}
+	if total > maxOptionsTotal {
+		return nil, fmt.Errorf("mount options are %d bytes, more than the %d allowed, reduce the options", total, maxOptionsTotal)
+	}
+	return args, nil
+}
+
+// mountArgs returns the mount helper arguments of v. The options were
+// checked by optionArgs when v was created.
+func mountArgs(v *ofsVolume, fs, dir string) []string {
+	args, _ := v.optionArgs()
+	if len(args) > len(v.groups)+1 {
+		log.Printf("Mount options of ObjectiveFS Volume '%s' (%d bytes) split into %d arguments", v.volume.Name, len(strings.Join(args, ",")), len(args))
+	}
+	return append(args, fs, dir)
+}
+
+func newVolume(name string, opts map[string]string, created string) (*ofsVolume, error) {
 	v := &ofsVolume{}
-	v.volume = &volume.Volume{Name: r.Name, Mountpoint: filepath.Join(volume.DefaultDockerRootDirectory, "objectivefs", r.Name), CreatedAt: time.Now().Format(time.RFC3339Nano)}
+	v.volume = &volume.Volume{Name: name, Mountpoint: filepath.Join(mountRoot, name), CreatedAt: created}
+	v.options = opts
 	v.use = make(map[string]bool)
+	v.groups = make(map[string]string)
+	v.tags = make(map[string]string)
+	v.fuseTimeouts = make(map[string]string)
+	v.timeouts = make(map[string]time.Duration)
 	v.opts = "auto"
-	for key, val := range r.Options {
-		switch key {
-		case "fs":
-			v.fs = val
-		case "options", "ptions":
-			v.opts = v.opts + "," + val
-		case "asap":
-			v.asap = true
-		default:
-			v.env = append(v.env, key+"="+val)
-		}
+	v.profile = opts["profile"]
+	all, err := profiles.expand(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for volume '%s': profile: %s", name, err.Error())
+	}
+	if err := parseOptions(v, all); err != nil {
+		return nil, err
+	}
+	v.orderEnv()
+	return v, nil
+}
+
+func (d *ofsDriver) Create(r *volume.CreateRequest) error {
+	if err := d.ready("create"); err != nil {
+		log.Printf("Create ObjectiveFS Volume '%s': %s", r.Name, err.Error())
+		return err
+	}
+	return d.create(r)
+}
+
+// create is Create without the readiness check, for the volumes the driver
+// creates by itself.
+func (d *ofsDriver) create(r *volume.CreateRequest) error {
+	log.Printf("Create ObjectiveFS Volume '%s'", r.Name)
+	if !volumeNameRe.MatchString(r.Name) {
+		return fmt.Errorf("invalid volume name '%s', must match %s", r.Name, volumeNameRe)
+	}
+	d.RLock()
+	_, ok := d.volumes[r.Name]
+	d.RUnlock()
+	if ok {
+		return fmt.Errorf("volume '%s' already exists", r.Name)
+	}
+	v, err := newVolume(r.Name, r.Options, time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+	if err := d.checkPassphrase(v); err != nil {
+		return err
+	}
+	if err := d.checkHooks(v); err != nil {
+		return err
+	}
+	if err := d.checkPropagation(v); err != nil {
+		return err
+	}
+
+	// The volume is locked before it becomes visible, so Mount calls made
+	// while mount_on_create is mounting it wait instead of mounting it a
+	// second time.
+	v.Lock()
+	defer v.Unlock()
+	d.Lock()
+	if _, ok := d.volumes[r.Name]; ok {
+		d.Unlock()
+		return fmt.Errorf("volume '%s' already exists", r.Name)
 	}
 	d.volumes[r.Name] = v
+	d.Unlock()
+	if d.config.precreate && !v.mountOnCreate {
+		if err := d.checkSymlink(v.volume.Mountpoint); err == nil {
+			err = d.makeMountpoint(v.volume.Mountpoint)
+		}
+		if err != nil {
+			v.removed = true
+			d.Lock()
+			delete(d.volumes, r.Name)
+			d.Unlock()
+			return fmt.Errorf("cannot create mountpoint of volume '%s': %s", r.Name, err.Error())
+		}
+	}
+	if v.mountOnCreate {
+		if err := d.mount(v); err != nil {
+			v.removed = true
+			d.Lock()
+			delete(d.volumes, r.Name)
+			d.Unlock()
+			return err
+		}
+	}
+	d.Lock()
+	d.saveState()
+	d.Unlock()
+	audit.record("create", v)
 	return nil
 }
 
-func (d ofsDriver) List() (*volume.ListResponse, error) {
-	d.Lock()
-	defer d.Unlock()
+// lookup returns the volume called name with its lock held. The driver
+// lock is only taken for the lookup itself, so a slow mount or unmount of
+// one volume does not hold up requests for the others. A volume lock is
+// never acquired while holding the driver lock.
+func (d *ofsDriver) lookup(name string) (*ofsVolume, error) {
+	d.RLock()
+	v, ok := d.volumes[name]
+	d.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("volume '%s' not found", name)
+	}
+	v.Lock()
+	if v.removed {
+		v.Unlock()
+		return nil, fmt.Errorf("volume '%s' not found", name)
+	}
+	return v, nil
+}
+
+// snapshot returns all volumes, unlocked.
+func (d *ofsDriver) snapshot() []*ofsVolume {
+	d.RLock()
+	defer d.RUnlock()
 
-	var vs []*volume.Volume
+	vs := make([]*ofsVolume, 0, len(d.volumes))
+	for _, v := range d.volumes {
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func (d *ofsDriver) List() (*volume.ListResponse, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	vs := make([]*volume.Volume, 0, len(d.volumes))
 	for _, v := range d.volumes {
 		vs = append(vs, v.volume)
 	}
 	return &volume.ListResponse{Volumes: vs}, nil
 }
 
-func (d ofsDriver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
-	d.Lock()
-	defer d.Unlock()
+func (d *ofsDriver) status(v *ofsVolume) map[string]interface{} {
+	s := map[string]interface{}{"fs": v.fs, "mount_style": d.config.mountStyle, "mounted": v.mounted, "users": len(v.use), "state": volumeState(v)}
+	if v.profile != "" {
+		s["profile"] = v.profile
+	}
+	if v.keyVersion != "" {
+		s["key_version"] = v.keyVersion
+	}
+	if len(v.tags) != 0 {
+		s["tags"] = v.tags
+	}
+	if v.mounted {
+		s["type"] = v.fstype
+	}
+	if v.mountOnCreate {
+		s["mount_on_create"] = true
+	}
+	if v.verifyRead {
+		s["verify_read"] = v.verifyPath
+	}
+	if v.prefix != "" {
+		s["prefix"] = v.prefix
+	}
+	if len(v.dns) != 0 {
+		s["dns"] = v.dns
+	}
+	if v.propagation != "" {
+		s["propagation"] = v.propagation
+	}
+	if v.nice != nil {
+		s["nice"] = *v.nice
+	}
+	for key, val := range v.fuseTimeouts {
+		s[key] = val
+	}
+	timeouts := make(map[string]string)
+	for _, kind := range timeoutKinds {
+		if t := d.timeout(v, kind); t != 0 {
+			timeouts[kind] = t.String()
+		} else {
+			timeouts[kind] = "none"
+		}
+	}
+	s["timeouts"] = timeouts
+	if len(v.warnings) != 0 {
+		s["warnings"] = v.warnings
+	}
+	if v.cacheDevice != "" {
+		s["cache_device"] = v.cacheDevice
+		s["cache_path"] = v.cacheDir
+	}
+	if v.maxAge != 0 {
+		s["max_mount_age"] = v.maxAge.String()
+		if v.mounted {
+			s["mount_age_remaining"] = ageRemaining(v, time.Now()).String()
+			s["rotation_due"] = v.rotationDue
+		}
+	}
+	if v.warm != nil {
+		s["warm"] = v.warm.status()
+	}
+	if !v.credsExpiry.IsZero() && v.mounted {
+		s["credentials_expiry"] = v.credsExpiry.Format(time.RFC3339)
+	}
+	if v.firstByte != 0 {
+		s["first_byte_latency"] = v.firstByte.String()
+	}
+	d.budgetStatus(v, s)
+	if len(v.crashes) != 0 {
+		s["crashes"] = len(v.crashes)
+	}
+	if io := ioStats.status(v.volume.Name); io != nil && v.mounted {
+		s["process_io"] = io
+	}
+	if n := len(v.ioErrors) - expired(v.ioErrors, d.config.ioErrorWindow, time.Now()); n != 0 {
+		s["io_errors"] = n
+	}
+	if !v.trippedAt.IsZero() {
+		s["state"] = "failed"
+		s["failed_since"] = v.trippedAt.Format(time.RFC3339)
+	}
+	return s
+}
 
-	v, ok := d.volumes[r.Name]
-	if !ok {
-		return &volume.GetResponse{}, fmt.Errorf("volume '%s' not found", r.Name)
+func (d *ofsDriver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
+	v, err := d.lookup(r.Name)
+	if err != nil {
+		return &volume.GetResponse{}, err
+	}
+	defer v.Unlock()
+
+	vol := *v.volume
+	vol.Status = d.status(v)
+	return &volume.GetResponse{Volume: &vol}, nil
+}
+
+// cmdPrefix returns the command the mount helper of v is run through.
+func (v *ofsVolume) cmdPrefix() []string {
+	if v.nice == nil {
+		return nil
+	}
+	return []string{niceBinary, "-n", strconv.Itoa(*v.nice)}
+}
+
+func (d *ofsDriver) mountFs(v *ofsVolume, fs, dir string) error {
+	creds, err := d.credentials(v)
+	if err != nil {
+		return fmt.Errorf("cannot get credentials for '%s': %s", v.volume.Name, err.Error())
+	}
+	cmd := d.config.mountCommand(v.cmdPrefix(), mountArgs(v, fs, dir)...)
+	cmd.Env = append(append([]string{}, v.env...), creds...)
+	log.Printf("Mount ObjectiveFS Volume '%s': '%s'", v.volume.Name, cmd)
+	if v.nice != nil {
+		log.Printf("Running ObjectiveFS Volume '%s' at niceness %d", v.volume.Name, *v.nice)
+	}
+	var output string
+	delay := d.config.licenseRetryDelay
+	for attempt := 0; ; attempt++ {
+		release := endpoints.acquire(endpoint(fs), d.config.endpointLimit)
+		output, err = run(cmd, d.timeout(v, "mount"))
+		release()
+		d.logOutput(v, cmd.Env, cmd.String(), output)
+		kind := ""
+		if err != nil {
+			kind = licenseError(output)
+		}
+		if kind != "" {
+			metrics.inc("objectivefs_license_errors_total", "kind", kind)
+		}
+		if kind != licenseTransient || attempt >= d.config.licenseRetries {
+			if kind == licenseInvalid {
+				return fmt.Errorf("mount of volume '%s' failed: license rejected, not retrying: %s", v.volume.Name, strings.TrimSpace(redact(cmd.Env, output)))
+			}
+			if kind == licenseTransient {
+				return fmt.Errorf("mount of volume '%s' failed: license server unavailable after %d attempts: %s", v.volume.Name, attempt+1, strings.TrimSpace(redact(cmd.Env, output)))
+			}
+			break
+		}
+		log.Printf("License server unavailable mounting ObjectiveFS Volume '%s', retrying in %s", v.volume.Name, delay)
+		time.Sleep(delay)
+		delay *= 2
+		cmd = d.config.mountCommand(v.cmdPrefix(), mountArgs(v, fs, dir)...)
+		cmd.Env = append(append([]string{}, v.env...), creds...)
+	}
+	v.warnings = optionWarnings(v.mountOptions(), output)
+	for _, w := range v.warnings {
+		log.Printf("Warning: ObjectiveFS Volume '%s': %s", v.volume.Name, w)
+	}
+	if err != nil {
+		return fmt.Errorf("unexpected error mounting '%s' check log (/var/log/syslog or /var/log/messages): %s", v.volume.Name, err.Error())
+	}
+	d.setExpiry(v, cmd.Env)
+	return nil
+}
+
+// users returns the IDs of the mounts using v, sorted and shown as
+// configured by OBJECTIVEFS_SHOW_USERS.
+func (d *ofsDriver) users(v *ofsVolume) []string {
+	if d.config.showUsers == "none" {
+		return nil
+	}
+	ids := make([]string, 0, len(v.use))
+	for id := range v.use {
+		if d.config.showUsers == "short" && len(id) > 12 {
+			id = id[:12]
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// makeMountpoint creates the mountpoint dir with the configured mode and
+// owner.
+func (d *ofsDriver) makeMountpoint(dir string) error {
+	if err := os.MkdirAll(dir, d.config.mountpointMode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, d.config.mountpointMode); err != nil {
+		return err
+	}
+	if d.config.mountpointUid >= 0 {
+		return os.Lchown(dir, d.config.mountpointUid, d.config.mountpointGid)
+	}
+	return nil
+}
+
+func (d *ofsDriver) mount(v *ofsVolume) error {
+	if err := checkParent(v.volume.Mountpoint); err != nil {
+		return err
+	}
+	if err := d.checkAdmission(); err != nil {
+		return err
+	}
+	if err := d.checkMemory(v); err != nil {
+		return err
+	}
+	if err := d.checkCacheLimit(v); err != nil {
+		return err
+	}
+	defer func() {
+		if !v.mounted {
+			caches.unset(v.volume.Name)
+		}
+	}()
+	if err := d.checkSymlink(v.volume.Mountpoint); err != nil {
+		return err
+	}
+	if err := d.makeMountpoint(v.volume.Mountpoint); err != nil {
+		return err
+	}
+	var err error
+	if len(v.layers) != 0 {
+		err = d.mountLayers(v)
+	} else {
+		err = d.mountFs(v, v.fs, v.volume.Mountpoint)
+	}
+	if err != nil {
+		return err
 	}
-	return &volume.GetResponse{Volume: v.volume}, nil
+	if v.fstype, err = verifyWithin(v.volume.Mountpoint, len(v.layers) != 0, d.timeout(v, "verify")); err != nil {
+		if v.fstype != "" || err == errVerifyTimeout {
+			d.umountDir(v.volume.Mountpoint, umountNormal, d.timeout(v, "unmount"))
+		}
+		if len(v.layers) != 0 {
+			d.umountLayers(v, len(v.layers), umountNormal)
+		}
+		return fmt.Errorf("mount of volume '%s' rejected: %s", v.volume.Name, err.Error())
+	}
+	v.mounted, v.mountedAt, v.rotationDue = true, time.Now(), false
+	caches.set(v)
+	d.markUsed(v)
+	d.transition("mount", v)
+	if v.propagation != "" {
+		if err := d.shareMount(v); err != nil {
+			d.umount(v, umountNormal)
+			return fmt.Errorf("mount of volume '%s' rejected: %s", v.volume.Name, err.Error())
+		}
+	}
+	if v.verifyRead {
+		if err := readSentinel(filepath.Join(v.volume.Mountpoint, v.verifyPath), d.timeout(v, "verify")); err != nil {
+			d.umount(v, umountNormal)
+			return fmt.Errorf("mount of volume '%s' rejected: cannot read '%s': %s", v.volume.Name, v.verifyPath, err.Error())
+		}
+		log.Printf("Read '%s' of ObjectiveFS Volume '%s'", v.verifyPath, v.volume.Name)
+	}
+	if v.probe {
+		d.probe(v)
+	}
+	return nil
 }
 
-func umount(v *ofsVolume) error {
+// Unmount policies. Each one first tries a plain umount; lazy then falls
+// back to umount -l, force to umount -f and then umount -l.
+const (
+	umountNormal = "normal"
+	umountLazy   = "lazy"
+	umountForce  = "force"
+)
+
+var umountSteps = map[string][][]string{
+	umountNormal: {nil},
+	umountLazy:   {nil, {"-l"}},
+	umountForce:  {nil, {"-f"}, {"-l"}},
+}
+
+// umountDir unmounts dir, escalating according to policy. A dir that
+// turns out not to be mounted, e.g. because it was unmounted by hand,
+// counts as success.
+func (d *ofsDriver) umountDir(dir, policy string, timeout time.Duration) error {
+	var out string
+	for _, flags := range umountSteps[policy] {
+		var err error
+		if out, err = run(d.config.command("umount", append(flags, dir)...), timeout); err == nil {
+			return nil
+		}
+		if err == errCommandTimeout {
+			out = fmt.Sprintf("timed out after %s", timeout)
+		}
+		if fstype, merr := mountType(dir); strings.Contains(out, "not mounted") || (merr == nil && fstype == "") {
+			log.Printf("'%s' already unmounted", dir)
+			return nil
+		}
+		log.Printf("umount %s'%s' failed: %s", strings.Join(append(flags, ""), " "), dir, strings.TrimSpace(out))
+	}
+	return fmt.Errorf("umount '%s' failed: %s", dir, strings.TrimSpace(out))
+}
+
+func (d *ofsDriver) umount(v *ofsVolume, policy string) error {
 	log.Printf("Unmount ObjectiveFS Volume '%s'", v.volume.Name)
 	if !v.mounted {
 		return nil
 	}
-	if err := exec.Command("umount", v.volume.Mountpoint).Run(); err != nil {
+	if v.warm != nil {
+		v.warm.cancel()
+	}
+	d.syncForUnmount(v, v.volume.Mountpoint)
+	if err := d.umountDir(v.volume.Mountpoint, policy, d.timeout(v, "unmount")); err != nil {
+		metrics.inc("objectivefs_unmounts_total", "result", "failure")
 		return err
 	}
-	if err := os.Remove(v.volume.Mountpoint); err != nil {
+	metrics.inc("objectivefs_unmounts_total", "result", "success")
+	if err := os.Remove(v.volume.Mountpoint); err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if len(v.layers) != 0 {
+		if err := d.umountLayers(v, len(v.layers), policy); err != nil {
+			return err
+		}
+	}
 	v.mounted = false
+	caches.unset(v.volume.Name)
+	d.setExpiry(v, nil)
+	d.transition("unmount", v)
 	return nil
 }
 
-func (d ofsDriver) Remove(r *volume.RemoveRequest) error {
-	d.Lock()
-	defer d.Unlock()
+func (d *ofsDriver) Remove(r *volume.RemoveRequest) error {
+	v, err := d.lookup(r.Name)
+	if err != nil {
+		return err
+	}
+	defer v.Unlock()
 
-	v, ok := d.volumes[r.Name]
-	if !ok {
-		return fmt.Errorf("volume '%s' not found", r.Name)
+	if err := d.ready("remove"); err != nil {
+		return err
 	}
 	if len(v.use) != 0 {
+		if users := d.users(v); len(users) != 0 {
+			return fmt.Errorf("volume '%s' currently in use (%d unique): %s", r.Name, len(v.use), strings.Join(users, ", "))
+		}
 		return fmt.Errorf("volume '%s' currently in use (%d unique)", r.Name, len(v.use))
 	}
-	if err := umount(v); err != nil {
+	if err := d.umount(v, d.config.removeUnmount); err != nil {
 		return err
 	}
+	// A mountpoint made at create, or left over, is removed once empty.
+	if err := os.Remove(v.volume.Mountpoint); err != nil && !os.IsNotExist(err) {
+		log.Printf("Cannot remove mountpoint of ObjectiveFS Volume '%s': %s", r.Name, err.Error())
+	}
+	v.removed = true
+	d.Lock()
 	delete(d.volumes, r.Name)
+	d.use.Lock()
+	delete(d.use.ids, r.Name)
+	delete(d.use.used, r.Name)
+	d.use.Unlock()
+	d.saveState()
+	d.Unlock()
+	metrics.unset("objectivefs_first_byte_seconds", "volume", r.Name)
+	audit.record("remove", v)
 	return nil
 }
 
-func (d ofsDriver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
-	d.Lock()
-	defer d.Unlock()
+func (d *ofsDriver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
+	v, err := d.lookup(r.Name)
+	if err != nil {
+		return &volume.PathResponse{}, err
+	}
+	defer v.Unlock()
 
-	v, ok := d.volumes[r.Name]
-	if !ok {
-		return &volume.PathResponse{}, fmt.Errorf("volume '%s' not found", r.Name)
+	if !v.mounted {
+		switch d.config.pathUnmounted {
+		case "empty":
+			return &volume.PathResponse{}, nil
+		case "error":
+			return &volume.PathResponse{}, fmt.Errorf("volume '%s' not mounted", r.Name)
+		}
 	}
 	return &volume.PathResponse{Mountpoint: v.volume.Mountpoint}, nil
 }
 
-func (d ofsDriver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
-	d.Lock()
-	defer d.Unlock()
-
-	v, ok := d.volumes[r.Name]
-	if !ok {
-		return &volume.MountResponse{}, fmt.Errorf("volume '%s' not found", r.Name)
+func (d *ofsDriver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
+	v, err := d.lookup(r.Name)
+	if err != nil {
+		return &volume.MountResponse{}, err
 	}
+	defer v.Unlock()
+
 	log.Printf("Attach ObjectiveFS Volume '%s' to '%s'", r.Name, r.ID)
+	if err := d.ready("mount"); err != nil {
+		return &volume.MountResponse{}, err
+	}
+	if d.tripped(v, time.Now()) {
+		return &volume.MountResponse{}, d.breakerError(v)
+	}
 	if !v.mounted {
-		if err := os.MkdirAll(v.volume.Mountpoint, 0755); err != nil {
+		if err := d.checkBudget(v); err != nil {
 			return &volume.MountResponse{}, err
 		}
-		cmd := exec.Command("/sbin/mount.objectivefs", "-o"+v.opts, v.fs, v.volume.Mountpoint)
-		cmd.Env = v.env
-		log.Printf("Mount ObjectiveFS Volume '%s': '%s'", r.Name, cmd)
-		if err := cmd.Run(); err != nil {
-			return &volume.MountResponse{}, fmt.Errorf("unexpected error mounting '%s' check log (/var/log/syslog or /var/log/messages): %s", r.Name, err.Error())
+		if err := d.mount(v); err != nil {
+			d.mountFailed(v, time.Now())
+			metrics.inc("objectivefs_mounts_total", "result", "failure")
+			return &volume.MountResponse{}, err
 		}
-		v.mounted = true
+		metrics.inc("objectivefs_mounts_total", "result", "success")
 	}
+	first := len(v.use) == 0
 	v.use[r.ID] = true
+	d.recordUse(v)
+	if first {
+		d.transition("first_user", v)
+	}
 	return &volume.MountResponse{Mountpoint: v.volume.Mountpoint}, nil
 }
 
-func (d ofsDriver) Unmount(r *volume.UnmountRequest) error {
-	d.Lock()
-	defer d.Unlock()
+func (d *ofsDriver) Unmount(r *volume.UnmountRequest) error {
+	v, err := d.lookup(r.Name)
+	if err != nil {
+		return err
+	}
+	defer v.Unlock()
 
-	v, ok := d.volumes[r.Name]
-	if !ok {
-		return fmt.Errorf("volume '%s' not found", r.Name)
+	if !v.use[r.ID] {
+		if d.config.strictUnmount {
+			return fmt.Errorf("volume '%s' not in use by '%s'", r.Name, r.ID)
+		}
+		log.Printf("Detach ObjectiveFS Volume '%s' from unknown user '%s'", r.Name, r.ID)
 	}
-	log.Printf("Detach ObjectiveFS Volume '%s' from '%s'", r.Name, r.ID)
+	last := len(v.use) != 0
 	delete(v.use, r.ID)
+	d.recordUse(v)
+	if last && len(v.use) == 0 {
+		d.transition("last_user", v)
+	}
+	if !v.mounted {
+		// The mount went away while the container still held it, so only
+		// the reference is left to drop.
+		log.Printf("Detach ObjectiveFS Volume '%s' from '%s' (not mounted, %d remaining)", r.Name, r.ID, len(v.use))
+		return nil
+	}
+	log.Printf("Detach ObjectiveFS Volume '%s' from '%s' (%d remaining)", r.Name, r.ID, len(v.use))
 	if len(v.use) == 0 && v.asap {
-		if err := umount(v); err != nil {
+		if err := d.umount(v, d.config.unmountPolicy); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (d ofsDriver) Capabilities() *volume.CapabilitiesResponse {
-	d.Lock()
-	defer d.Unlock()
-
+func (d *ofsDriver) Capabilities() *volume.CapabilitiesResponse {
 	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: "local"}}
 }
 
+func rootGid() (int, error) {
+	u, err := user.Lookup("root")
+	if err != nil {
+		return 0, fmt.Errorf("cannot look up group of user root: %s", err.Error())
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gid '%s' of user root", u.Gid)
+	}
+	return gid, nil
+}
+
 func main() {
 	log.Printf("Starting ObjectiveFS Volume Driver, version " + version)
-	d := ofsDriver{volumes: make(map[string]*ofsVolume)}
-	h := volume.NewHandler(d)
-	u, _ := user.Lookup("root")
-	gid, _ := strconv.Atoi(u.Gid)
-	h.ServeUnix("objectivefs", gid)
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mountRoot = config.mountRoot
+	gid, err := rootGid()
+	if err != nil {
+		if config.requireRootGid {
+			log.Fatal(err)
+		}
+		log.Printf("%s, using gid 0 for the plugin socket", err.Error())
+	}
+	log.Printf("ObjectiveFS Volume Driver configuration: %s", config.summary(gid))
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		log.Printf("Warning: /dev/fuse not available, mounts will fail: %s", err.Error())
+	}
+	lock, err := lockPid(config.lockFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := &ofsDriver{config: config, volumes: make(map[string]*ofsVolume), use: useState{ids: make(map[string][]string), used: make(map[string]bool)}, mode: modeNormal}
+	if config.allowSharedPropagation {
+		if err := d.shareRoot(config.timeouts["mount"]); err != nil {
+			log.Fatalf("Cannot make mount root '%s' a shared subtree: %s", mountRoot, err.Error())
+		}
+	}
+	var spec string
+	if config.specDir != "" {
+		if spec, err = writeSpec(config.specDir, config.driverName, config.socket); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving ObjectiveFS Volume Driver as '%s' through '%s'", config.driverName, spec)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Printf("Stopping ObjectiveFS Volume Driver on %s", <-sig)
+		if spec != "" {
+			os.Remove(spec)
+		}
+		if config.unmountOnExit {
+			for _, res := range d.unmountAll(config.unmountPolicy) {
+				if res.Err != "" {
+					log.Printf("Unmount on exit of ObjectiveFS Volume '%s' failed: %s", res.Volume, res.Err)
+				}
+			}
+		}
+		lock.release()
+		os.Exit(0)
+	}()
+	if config.auditLog != "" {
+		if err := audit.open(config.auditLog); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var driver volume.Driver = d
+	if config.otlpEndpoint != "" {
+		traces.start(config.otlpEndpoint)
+		driver = tracedDriver{d}
+	}
+	h := volume.NewHandler(driver)
+	h.HandleFunc("/features", d.features)
+	h.HandleFunc("/health", d.health)
+	h.HandleFunc("/metrics", metrics.serve)
+	h.HandleFunc("/mode", d.setMode)
+	h.HandleFunc("/stats", d.stats)
+	h.HandleFunc("/reconcile", d.reconcileHandler)
+	h.HandleFunc("/resources", d.resources)
+	h.HandleFunc("/stale-mounts", d.staleMountsHandler)
+	h.HandleFunc("/unmount-all", d.unmountAllHandler)
+	h.HandleFunc("/volumes/", d.volumeAction)
+	if config.metricsAddr != "" {
+		metrics.setListenState("starting")
+		go metrics.listen(config.metricsAddr, config.metricsRetry)
+	}
+	if config.profilesFile != "" {
+		if err := profiles.load(config.profilesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.stateFile != "" {
+		d.loadState()
+		if config.usePersist == "interval" {
+			go d.useLoop()
+		}
+	}
+	if config.volumesFile != "" {
+		d.reconcile()
+	}
+	d.reclaim()
+	if config.volumesFile != "" || config.profilesFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if config.profilesFile != "" {
+					if err := profiles.load(config.profilesFile); err != nil {
+						log.Printf("Reload profiles: %s", err.Error())
+					}
+				}
+				if config.volumesFile != "" {
+					d.reconcile()
+				}
+			}
+		}()
+	}
+	if config.healthInterval > 0 {
+		go d.checkLoop()
+	}
+	if config.ioStatsInterval > 0 {
+		go d.ioLoop()
+	}
+	if config.idleRemoveAge > 0 {
+		go d.idleLoop()
+	}
+	if config.syncInterval > 0 {
+		go d.syncLoop()
+	}
+	if err := h.ServeUnix(config.socket, gid); err != nil {
+		if spec != "" {
+			os.Remove(spec)
+		}
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,713 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// testDriver returns a driver with the default configuration and its
+// mount root in a temporary directory.
+func testDriver(t *testing.T) *ofsDriver {
+	t.Helper()
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := mountRoot
+	mountRoot = filepath.Join(t.TempDir(), "objectivefs")
+	config.mountRoot = mountRoot
+	t.Cleanup(func() { mountRoot = old })
+	return &ofsDriver{config: config, volumes: make(map[string]*ofsVolume), use: useState{ids: make(map[string][]string), used: make(map[string]bool)}, mode: modeNormal}
+}
+
+// fakeMount is a mount helper and wrapper that record mounts in a fake
+// mountinfo file instead of mounting.
+type fakeMount struct {
+	dir string
+}
+
+const fakeHelper = `#!/bin/sh
+PATH=/usr/bin:/bin
+cd @DIR@
+exec 9>lock
+flock 9
+echo "$@" >> calls
+nice > niceness
+if [ -f fail ]; then
+	cat fail
+	exit 1
+fi
+for a; do dir=$a; done
+echo "1 1 0:1 / $dir rw - fuse.objectivefs objectivefs rw" >> mountinfo
+`
+
+const fakeWrapper = `#!/bin/sh
+PATH=/usr/bin:/bin
+case "$1" in
+umount|mount) ;;
+*) exec "$@" ;;
+esac
+cd @DIR@
+exec 9>lock
+flock 9
+echo "$@" >> calls
+for a; do dir=$a; done
+if [ "$1" = mount ] && [ -f mount-hang ]; then
+	flock -u 9
+	sleep 10
+fi
+if [ "$1" = mount ]; then
+	echo "1 1 0:1 / $dir rw - overlay overlay rw" >> mountinfo
+	exit 0
+fi
+if [ -f umount-fail ] && grep -qx -e busy -e "$dir" umount-fail; then
+	echo "umount: $dir: target is busy."
+	exit 32
+fi
+if ! grep -q " $dir rw " mountinfo; then
+	echo "umount: $dir: not mounted."
+	exit 32
+fi
+grep -v " $dir rw " mountinfo > mountinfo.new
+mv mountinfo.new mountinfo
+`
+
+// fakeMounts makes d mount and unmount through a fakeMount.
+func fakeMounts(t *testing.T, d *ofsDriver) *fakeMount {
+	t.Helper()
+	f := &fakeMount{dir: t.TempDir()}
+	write := func(name, content string, mode os.FileMode) {
+		if err := ioutil.WriteFile(filepath.Join(f.dir, name), []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("mountinfo", "", 0644)
+	write("mount.objectivefs", strings.Replace(fakeHelper, "@DIR@", f.dir, -1), 0755)
+	write("wrapper", strings.Replace(fakeWrapper, "@DIR@", f.dir, -1), 0755)
+	d.config.mountBinary = filepath.Join(f.dir, "mount.objectivefs")
+	d.config.mountWrapper = []string{filepath.Join(f.dir, "wrapper")}
+	old := mountinfoPath
+	mountinfoPath = filepath.Join(f.dir, "mountinfo")
+	t.Cleanup(func() { mountinfoPath = old })
+	return f
+}
+
+// calls returns the mount and umount commands run, one per line.
+func (f *fakeMount) calls() []string {
+	b, _ := ioutil.ReadFile(filepath.Join(f.dir, "calls"))
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// mounts returns the number of filesystems mounted.
+func (f *fakeMount) mounts() int {
+	mounts, _ := readMountinfo()
+	return len(mounts)
+}
+
+// fail makes the following mounts fail with output, or succeed again if
+// output is empty. For "umount-fail", output is "busy" to fail every
+// unmount or the directories to fail the unmounts of, one per line. "mount-hang" makes the mount commands of the wrapper hang.
+func (f *fakeMount) fail(t *testing.T, name, output string) {
+	path := filepath.Join(f.dir, name)
+	if output == "" {
+		os.Remove(path)
+		return
+	}
+	if err := ioutil.WriteFile(path, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testVolume(t *testing.T, opts map[string]string) *ofsVolume {
+	t.Helper()
+	v, err := newVolume("vol", opts, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMountArgs(t *testing.T) {
+	tests := []struct {
+		opts map[string]string
+		want []string
+	}{
+		{map[string]string{"fs": "myfs"}, []string{"-oauto", "myfs", "/mnt"}},
+		{map[string]string{"fs": "myfs", "options": "mt,noatime"}, []string{"-oauto,mt,noatime", "myfs", "/mnt"}},
+		{map[string]string{"fs": "myfs", "options.data": "mt", "options.cache": "ocache"}, []string{"-oauto", "-oocache", "-omt", "myfs", "/mnt"}},
+	}
+	for _, tt := range tests {
+		v := testVolume(t, tt.opts)
+		if got := mountArgs(v, v.fs, "/mnt"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mountArgs(%v) = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestUnmountStrict(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		d := testDriver(t)
+		d.config.strictUnmount = strict
+		v := testVolume(t, map[string]string{"fs": "myfs"})
+		v.use["a"] = true
+		d.volumes["vol"] = v
+		err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "b"})
+		if (err != nil) != strict {
+			t.Errorf("strict %v: Unmount by unknown user: %v", strict, err)
+		}
+		if !v.use["a"] {
+			t.Errorf("strict %v: Unmount by unknown user dropped user 'a'", strict)
+		}
+		if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "a"}); err != nil {
+			t.Errorf("strict %v: Unmount: %v", strict, err)
+		}
+		if len(v.use) != 0 {
+			t.Errorf("strict %v: users left after Unmount: %v", strict, v.use)
+		}
+	}
+}
+
+// manyVolumes returns a driver with n volumes.
+func manyVolumes(n int) *ofsDriver {
+	d := &ofsDriver{volumes: make(map[string]*ofsVolume, n)}
+	for i := 0; i < n; i++ {
+		name := "vol" + strconv.Itoa(i)
+		d.volumes[name] = &ofsVolume{volume: &volume.Volume{Name: name, Mountpoint: filepath.Join(mountRoot, name)}}
+	}
+	return d
+}
+
+func TestList(t *testing.T) {
+	d := manyVolumes(100)
+	res, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Volumes) != 100 {
+		t.Errorf("List returned %d volumes, want 100", len(res.Volumes))
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	d := manyVolumes(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.List(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPathUnmounted(t *testing.T) {
+	tests := []struct {
+		policy  string
+		mounted bool
+		empty   bool
+		fails   bool
+	}{
+		{"mountpoint", false, false, false},
+		{"empty", false, true, false},
+		{"error", false, true, true},
+		{"empty", true, false, false},
+		{"error", true, false, false},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.pathUnmounted = tt.policy
+		v := testVolume(t, map[string]string{"fs": "myfs"})
+		v.mounted = tt.mounted
+		d.volumes["vol"] = v
+		res, err := d.Path(&volume.PathRequest{Name: "vol"})
+		if (err != nil) != tt.fails || (res.Mountpoint == "") != tt.empty {
+			t.Errorf("%s, mounted %v: Path = %q, %v", tt.policy, tt.mounted, res.Mountpoint, err)
+		}
+	}
+}
+
+func TestMountOnCreate(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "eager", Options: map[string]string{"fs": "myfs", "mount_on_create": "true"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := d.volumes["eager"]; v == nil || !v.mounted {
+		t.Error("mount_on_create volume not mounted")
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "lazy", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.mounts() != 1 {
+		t.Errorf("%d mounts, want 1", f.mounts())
+	}
+	f.fail(t, "fail", "bad credentials")
+	if err := d.Create(&volume.CreateRequest{Name: "broken", Options: map[string]string{"fs": "myfs", "mount_on_create": "true"}}); err == nil {
+		t.Error("Create succeeded with a failing mount")
+	}
+	if _, ok := d.volumes["broken"]; ok {
+		t.Error("volume kept after its mount on create failed")
+	}
+}
+
+// helperCalls returns how many times the mount helper ran.
+func (f *fakeMount) helperCalls() int {
+	n := 0
+	for _, c := range f.calls() {
+		if strings.HasPrefix(c, "-o") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConcurrentMounts(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: id}); err != nil {
+				t.Errorf("Mount by %s: %v", id, err)
+			}
+		}("c" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	if n := f.helperCalls(); n != 1 {
+		t.Errorf("mount helper ran %d times, want 1", n)
+	}
+	if n := len(d.volumes["vol"].use); n != 10 {
+		t.Errorf("%d users, want 10", n)
+	}
+}
+
+func TestConcurrentMountRemove(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	for i := 0; i < 20; i++ {
+		if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+			t.Fatal(err)
+		}
+		var mountErr, removeErr error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, mountErr = d.Mount(&volume.MountRequest{Name: "vol", ID: "c"})
+		}()
+		go func() {
+			defer wg.Done()
+			removeErr = d.Remove(&volume.RemoveRequest{Name: "vol"})
+		}()
+		wg.Wait()
+		d.RLock()
+		_, exists := d.volumes["vol"]
+		d.RUnlock()
+		switch {
+		case mountErr == nil && removeErr != nil:
+			// Mounted first: the remove is refused while in use.
+			if !exists || f.mounts() != 1 {
+				t.Fatalf("mounted volume: exists %v, %d mounts", exists, f.mounts())
+			}
+			if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+				t.Fatal(err)
+			}
+		case mountErr != nil && removeErr == nil:
+			// Removed first: the mount finds no volume.
+			if exists {
+				t.Fatal("removed volume still listed")
+			}
+		default:
+			t.Fatalf("Mount: %v, Remove: %v, want exactly one to succeed", mountErr, removeErr)
+		}
+		if f.mounts() != 0 {
+			t.Fatalf("%d mounts left after remove", f.mounts())
+		}
+	}
+}
+
+func TestRootGid(t *testing.T) {
+	if gid, err := rootGid(); err != nil || gid != 0 {
+		t.Errorf("rootGid() = %d, %v, want 0", gid, err)
+	}
+	t.Setenv("OBJECTIVEFS_MOUNT_BINARY", "/bin/true")
+	for val, want := range map[string]bool{"": false, "true": true, "0": false} {
+		t.Setenv("OBJECTIVEFS_REQUIRE_ROOT_GID", val)
+		if c, err := loadConfig(); err != nil || c.requireRootGid != want {
+			t.Errorf("OBJECTIVEFS_REQUIRE_ROOT_GID=%q: %v, %v", val, c.requireRootGid, err)
+		}
+	}
+	t.Setenv("OBJECTIVEFS_REQUIRE_ROOT_GID", "maybe")
+	if _, err := loadConfig(); err == nil {
+		t.Error("invalid OBJECTIVEFS_REQUIRE_ROOT_GID accepted")
+	}
+}
+
+func TestUmountDir(t *testing.T) {
+	tests := []struct {
+		mounted, busy bool
+		ok            bool
+	}{
+		{true, false, true},
+		{false, false, true},
+		{false, true, true},
+		{true, true, false},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		f := fakeMounts(t, d)
+		dir := filepath.Join(mountRoot, "vol")
+		if tt.mounted {
+			if err := ioutil.WriteFile(mountinfoPath, []byte("1 1 0:1 / "+dir+" rw - fuse.objectivefs objectivefs rw\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.busy {
+			f.fail(t, "umount-fail", "busy")
+		}
+		if err := d.umountDir(dir, umountNormal, 0); (err == nil) != tt.ok {
+			t.Errorf("mounted %v, busy %v: umountDir: %v", tt.mounted, tt.busy, err)
+		}
+	}
+}
+
+func TestRemoveUnmountedByHand(t *testing.T) {
+	d := testDriver(t)
+	fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(mountinfoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err != nil {
+		t.Fatalf("Remove of a volume unmounted by hand: %v", err)
+	}
+	if v.mounted {
+		t.Error("volume still marked mounted")
+	}
+	if _, err := os.Stat(v.volume.Mountpoint); !os.IsNotExist(err) {
+		t.Errorf("mountpoint left behind: %v", err)
+	}
+}
+
+// umounts returns the umount commands run.
+func (f *fakeMount) umounts() []string {
+	var umounts []string
+	for _, c := range f.calls() {
+		if strings.HasPrefix(c, "umount ") {
+			umounts = append(umounts, c)
+		}
+	}
+	return umounts
+}
+
+func TestUmountEscalation(t *testing.T) {
+	dir := "/mnt/vol"
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{umountNormal, []string{"umount " + dir}},
+		{umountLazy, []string{"umount " + dir, "umount -l " + dir}},
+		{umountForce, []string{"umount " + dir, "umount -f " + dir, "umount -l " + dir}},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		f := fakeMounts(t, d)
+		if err := ioutil.WriteFile(mountinfoPath, []byte("1 1 0:1 / "+dir+" rw - fuse.objectivefs objectivefs rw\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f.fail(t, "umount-fail", "busy")
+		if err := d.umountDir(dir, tt.policy, 0); err == nil {
+			t.Errorf("%s: umount of a busy mount succeeded", tt.policy)
+		}
+		if got := f.umounts(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ran %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveUnmountPolicy(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	d.config.removeUnmount = umountLazy
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "asap": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	mp := d.volumes["vol"].volume.Mountpoint
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	f.fail(t, "umount-fail", "busy")
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c"}); err == nil {
+		t.Error("Unmount of a busy mount succeeded")
+	}
+	if got, want := f.umounts(), []string{"umount " + mp}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmount ran %q, want %q", got, want)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol"}); err == nil {
+		t.Error("Remove of a busy mount succeeded")
+	}
+	if got, want := f.umounts(), []string{"umount " + mp, "umount " + mp, "umount -l " + mp}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Remove ran %q, want %q", got, want)
+	}
+}
+
+func TestUnmountNotMounted(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "asap": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c2"}); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	// E.g. unmounted by the idle timeout while still referenced.
+	if err := d.umount(v, umountNormal); err != nil {
+		t.Fatal(err)
+	}
+	n := len(f.umounts())
+	for _, id := range []string{"c1", "c2"} {
+		if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: id}); err != nil {
+			t.Errorf("Unmount by %s of a volume not mounted: %v", id, err)
+		}
+		if v.use[id] {
+			t.Errorf("Unmount by %s kept its reference", id)
+		}
+	}
+	if len(f.umounts()) != n {
+		t.Errorf("Unmount of a volume not mounted ran umount: %q", f.umounts()[n:])
+	}
+}
+
+func TestConcurrentMountOnCreate(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		d := testDriver(t)
+		f := fakeMounts(t, d)
+		d.config.precreate = i%2 == 1
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "mount_on_create": "true"}}); err != nil {
+				t.Errorf("Create: %v", err)
+			}
+		}()
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				// A Mount before the volume exists fails, any later one
+				// shares the mount of Create.
+				if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: id}); err != nil && !strings.Contains(err.Error(), "not found") {
+					t.Errorf("Mount by %s: %v", id, err)
+				}
+			}("c" + strconv.Itoa(j))
+		}
+		wg.Wait()
+		if n := f.helperCalls(); n != 1 {
+			t.Fatalf("mount helper ran %d times, want 1", n)
+		}
+		if n := f.mounts(); n != 1 {
+			t.Fatalf("%d mounts, want 1", n)
+		}
+	}
+}
+
+func TestMakeMountpoint(t *testing.T) {
+	d := testDriver(t)
+	d.config.mountpointMode = 0750
+	d.config.mountpointUid, d.config.mountpointGid = os.Getuid(), os.Getgid()
+	dir := filepath.Join(mountRoot, "vol")
+	for i := 0; i < 2; i++ {
+		if err := d.makeMountpoint(dir); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0750 {
+			t.Errorf("mountpoint mode %o, want 750", fi.Mode().Perm())
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if int(st.Uid) != os.Getuid() || int(st.Gid) != os.Getgid() {
+			t.Errorf("mountpoint owned by %d:%d", st.Uid, st.Gid)
+		}
+		// An existing mountpoint gets the configured mode too.
+		os.Chmod(dir, 0777)
+	}
+}
+
+func TestVolumeNames(t *testing.T) {
+	d := testDriver(t)
+	for name, ok := range map[string]bool{
+		"vol":      true,
+		"My.vol_1": true,
+		"a-b":      true,
+		"v":        false,
+		".layers":  false,
+		"_vol":     false,
+		"a/b":      false,
+		"..":       false,
+		"a b":      false,
+	} {
+		err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"fs": "myfs"}})
+		if (err == nil) != ok {
+			t.Errorf("Create(%q): %v, want ok %v", name, err, ok)
+		}
+	}
+	// Names map to mountpoints one to one, so only the exact name collides.
+	for _, name := range []string{"Vol", "vol.", "vol_"} {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"fs": "myfs"}}); err != nil {
+			t.Errorf("Create(%q) next to 'vol': %v", name, err)
+		}
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err == nil {
+		t.Error("second Create of 'vol' succeeded")
+	}
+	res, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountpoints := make(map[string]string)
+	for _, v := range res.Volumes {
+		if other, ok := mountpoints[v.Mountpoint]; ok {
+			t.Errorf("volumes %q and %q share mountpoint %q", other, v.Name, v.Mountpoint)
+		}
+		mountpoints[v.Mountpoint] = v.Name
+		if v.Mountpoint != filepath.Join(mountRoot, v.Name) {
+			t.Errorf("volume %q has mountpoint %q", v.Name, v.Mountpoint)
+		}
+	}
+}
+
+func TestSplitOptions(t *testing.T) {
+	tests := []struct {
+		opts string
+		max  int
+		want []string
+	}{
+		{"auto", 10, []string{"auto"}},
+		{"auto,mt,ro", 10, []string{"auto,mt,ro"}},
+		{"auto,mt,ro,x", 10, []string{"auto,mt,ro", "x"}},
+		{"abcdefghij,k", 10, []string{"abcdefghij", "k"}},
+		{"a,abcdefghij", 10, []string{"a", "abcdefghij"}},
+		{"abcdefghijk", 10, nil},
+		{"a,abcdefghijk", 10, nil},
+	}
+	for _, tt := range tests {
+		got, err := splitOptions(tt.opts, tt.max)
+		if (err == nil) != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitOptions(%q, %d) = %q, %v, want %q", tt.opts, tt.max, got, err, tt.want)
+		}
+	}
+}
+
+func TestOptionArgs(t *testing.T) {
+	long := func(n, size int) string {
+		var opts []string
+		for i := 0; i < n; i++ {
+			opts = append(opts, fmt.Sprintf("o%d=%s", i, strings.Repeat("x", size-len(fmt.Sprintf("o%d=", i)))))
+		}
+		return strings.Join(opts, ",")
+	}
+	tests := []struct {
+		options string
+		args    int
+		ok      bool
+	}{
+		{long(1, maxOptionArg-len("auto,")), 1, true},
+		{long(1, maxOptionArg-len("auto,")+1), 2, true},
+		{long(1, maxOptionArg+1), 0, false},
+		{long(16, maxOptionArg), 17, true},
+		{long(17, maxOptionArg), 0, false},
+	}
+	for _, tt := range tests {
+		v, err := newVolume("vol", map[string]string{"fs": "myfs", "options": tt.options}, "")
+		if (err == nil) != tt.ok {
+			t.Errorf("%d bytes of options: %v, want ok %v", len(tt.options), err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		args, _ := v.optionArgs()
+		if len(args) != tt.args {
+			t.Errorf("%d bytes of options: %d arguments, want %d", len(tt.options), len(args), tt.args)
+		}
+		for _, a := range args {
+			if len(a) > len("-o")+maxOptionArg {
+				t.Errorf("%d bytes of options: argument of %d bytes", len(tt.options), len(a))
+			}
+		}
+	}
+}
+
+func TestMountDaemonOutput(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	helper := filepath.Join(f.dir, "daemon")
+	script := "#!/bin/sh\nsleep 5 &\nexec " + d.config.mountBinary + " \"$@\"\n"
+	if err := ioutil.WriteFile(helper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d.config.mountBinary = helper
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("mount took %s with a daemon holding its output", took)
+	}
+}
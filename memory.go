@@ -0,0 +1,106 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var meminfoPath = "/proc/meminfo"
+
+// defaultCacheSize is the memory cache ObjectiveFS uses without CACHESIZE.
+const defaultCacheSize = "20%"
+
+// parseMeminfo returns the fields of /proc/meminfo in bytes.
+func parseMeminfo(r io.Reader) (map[string]uint64, error) {
+	info := make(map[string]uint64)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed meminfo line '%s'", s.Text())
+		}
+		if len(f) == 3 && f[2] == "kB" {
+			n *= 1024
+		}
+		info[strings.TrimSuffix(f[0], ":")] = n
+	}
+	return info, s.Err()
+}
+
+// cacheSize returns the memory cache size of a CACHESIZE value, which is
+// either a size or a percentage of total memory.
+func cacheSize(val string, total uint64) (uint64, error) {
+	if strings.HasSuffix(val, "%") {
+		p, err := strconv.ParseUint(strings.TrimSuffix(val, "%"), 10, 64)
+		if err != nil || p > 100 {
+			return 0, fmt.Errorf("invalid cache size '%s'", val)
+		}
+		return total / 100 * p, nil
+	}
+	return parseSize(val)
+}
+
+// checkMemory compares the memory cache that mounting v would take, plus
+// the configured margin, with the memory available on the host.
+func (d *ofsDriver) checkMemory(v *ofsVolume) error {
+	if d.config.memoryCheck == "off" {
+		return nil
+	}
+	f, err := os.Open(meminfoPath)
+	if err != nil {
+		log.Printf("Skip memory check of ObjectiveFS Volume '%s': %s", v.volume.Name, err.Error())
+		return nil
+	}
+	info, err := parseMeminfo(f)
+	f.Close()
+	if err != nil {
+		log.Printf("Skip memory check of ObjectiveFS Volume '%s': %s", v.volume.Name, err.Error())
+		return nil
+	}
+	val, ok := envValue(v, "CACHESIZE")
+	if !ok {
+		val = defaultCacheSize
+	}
+	size, err := cacheSize(val, info["MemTotal"])
+	if err != nil {
+		log.Printf("Skip memory check of ObjectiveFS Volume '%s': %s", v.volume.Name, err.Error())
+		return nil
+	}
+	processes := uint64(1)
+	if len(v.layers) != 0 {
+		processes = uint64(len(v.layers))
+	}
+	need, avail := size*processes+d.config.memoryMargin, info["MemAvailable"]
+	if avail >= need {
+		log.Printf("Memory check of ObjectiveFS Volume '%s' passed: %d bytes available, %d needed", v.volume.Name, avail, need)
+		return nil
+	}
+	if d.config.memoryCheck == "warn" {
+		log.Printf("Warning: ObjectiveFS Volume '%s' needs %d bytes of memory, only %d available", v.volume.Name, need, avail)
+		return nil
+	}
+	log.Printf("Refuse to mount ObjectiveFS Volume '%s': %d bytes of memory needed, %d available", v.volume.Name, need, avail)
+	return fmt.Errorf("not enough memory to mount volume '%s': %d bytes needed, %d available", v.volume.Name, need, avail)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMeminfo = `MemTotal:        8000000 kB
+MemFree:          500000 kB
+MemAvailable:    4000000 kB
+HugePages_Total:       0
+`
+
+func TestParseMeminfo(t *testing.T) {
+	info, err := parseMeminfo(strings.NewReader(testMeminfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["MemTotal"] != 8000000*1024 || info["MemAvailable"] != 4000000*1024 || info["HugePages_Total"] != 0 || len(info) != 4 {
+		t.Errorf("parseMeminfo = %v", info)
+	}
+	if _, err := parseMeminfo(strings.NewReader("MemTotal: lots kB\n")); err == nil {
+		t.Error("malformed meminfo accepted")
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	tests := []struct {
+		val  string
+		want uint64
+		ok   bool
+	}{
+		{"20%", 200, true},
+		{"100%", 1000, true},
+		{"0%", 0, true},
+		{"101%", 0, false},
+		{"x%", 0, false},
+		{"2M", 2 << 20, true},
+		{"lots", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := cacheSize(tt.val, 1000)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("cacheSize(%q) = %d, %v, want %d", tt.val, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	if err := ioutil.WriteFile(path, []byte(testMeminfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := meminfoPath
+	meminfoPath = path
+	defer func() { meminfoPath = old }()
+	tests := []struct {
+		check string
+		opts  map[string]string
+		ok    bool
+	}{
+		{"refuse", map[string]string{"fs": "myfs"}, true},
+		{"refuse", map[string]string{"fs": "myfs", "CACHESIZE": "3G"}, true},
+		{"refuse", map[string]string{"fs": "myfs", "CACHESIZE": "4G"}, false},
+		{"refuse", map[string]string{"fs": "myfs", "CACHESIZE": "45%"}, true},
+		{"refuse", map[string]string{"fs": "myfs", "CACHESIZE": "50%"}, false},
+		{"refuse", map[string]string{"fs": "s3://a,s3://b,s3://c,s3://d", "CACHESIZE": "1G"}, false},
+		{"warn", map[string]string{"fs": "myfs", "CACHESIZE": "4G"}, true},
+		{"off", map[string]string{"fs": "myfs", "CACHESIZE": "4G"}, true},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.memoryCheck = tt.check
+		if err := d.checkMemory(testVolume(t, tt.opts)); (err == nil) != tt.ok {
+			t.Errorf("%s %v: %v, want ok %v", tt.check, tt.opts, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ofsMetrics keeps counters in the Prometheus text format, keyed by metric
+// name and then by the rendered label set.
+type ofsMetrics struct {
+	sync.Mutex
+	counters map[string]map[string]uint64
+	gauges   map[string]map[string]float64
+
+	listenState string
+}
+
+var metrics = &ofsMetrics{counters: make(map[string]map[string]uint64), gauges: make(map[string]map[string]float64)}
+
+func labels(kv ...string) string {
+	var ls []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		ls = append(ls, fmt.Sprintf("%s=%q", kv[i], kv[i+1]))
+	}
+	if len(ls) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(ls, ",") + "}"
+}
+
+func (m *ofsMetrics) inc(name string, kv ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]uint64)
+	}
+	m.counters[name][labels(kv...)]++
+}
+
+func (m *ofsMetrics) get(name string, kv ...string) uint64 {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.counters[name][labels(kv...)]
+}
+
+func (m *ofsMetrics) set(name string, val float64, kv ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.gauges[name] == nil {
+		m.gauges[name] = make(map[string]float64)
+	}
+	m.gauges[name][labels(kv...)] = val
+}
+
+func (m *ofsMetrics) unset(name string, kv ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.gauges[name], labels(kv...))
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *ofsMetrics) serve(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	var names []string
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	for name := range m.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		if c, ok := m.counters[name]; ok {
+			fmt.Fprintf(w, "# TYPE %s counter\n", name)
+			for _, ls := range sortedKeys(c) {
+				fmt.Fprintf(w, "%s%s %d\n", name, ls, c[ls])
+			}
+			continue
+		}
+		g := m.gauges[name]
+		var keys []string
+		for k := range g {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, ls := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, ls, g[ls])
+		}
+	}
+}
+
+func (m *ofsMetrics) setListenState(state string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.listenState = state
+}
+
+func (m *ofsMetrics) getListenState() string {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.listenState
+}
+
+// listen serves the metrics on a TCP address in addition to the plugin
+// socket. Failing to bind is not fatal: metrics are reported as disabled
+// and, with a retry interval, binding is attempted again later.
+func (m *ofsMetrics) listen(addr string, retry time.Duration) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serve)
+	for {
+		l, err := net.Listen("tcp", addr)
+		if err == nil {
+			log.Printf("Serving metrics on '%s'", addr)
+			m.setListenState("serving on " + addr)
+			err = http.Serve(l, mux)
+		}
+		log.Printf("Metrics disabled, cannot serve on '%s': %s", addr, err.Error())
+		m.setListenState("disabled: " + err.Error())
+		if retry == 0 {
+			return
+		}
+		time.Sleep(retry)
+	}
+}
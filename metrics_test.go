@@ -0,0 +1,59 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newMetrics() *ofsMetrics {
+	return &ofsMetrics{counters: make(map[string]map[string]uint64), gauges: make(map[string]map[string]float64)}
+}
+
+func TestMetricsServe(t *testing.T) {
+	m := newMetrics()
+	m.inc("objectivefs_mounts_total", "result", "success")
+	m.inc("objectivefs_mounts_total", "result", "success")
+	m.inc("objectivefs_mounts_total", "result", "failure")
+	m.set("objectivefs_first_byte_seconds", 0.25, "volume", "a")
+	m.set("objectivefs_first_byte_seconds", 1, "volume", "b")
+	m.unset("objectivefs_first_byte_seconds", "volume", "b")
+	w := httptest.NewRecorder()
+	m.serve(w, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# TYPE objectivefs_first_byte_seconds gauge
+objectivefs_first_byte_seconds{volume="a"} 0.25
+# TYPE objectivefs_mounts_total counter
+objectivefs_mounts_total{result="failure"} 1
+objectivefs_mounts_total{result="success"} 2
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricsListenFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m := newMetrics()
+	m.listen(l.Addr().String(), 0)
+	if state := m.getListenState(); !strings.HasPrefix(state, "disabled: ") {
+		t.Errorf("listen state %q after failed bind, want disabled", state)
+	}
+}
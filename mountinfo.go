@@ -0,0 +1,135 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var mountinfoPath = "/proc/self/mountinfo"
+
+var mountinfoUnescape = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+type mountEntry struct {
+	dir    string
+	fstype string
+	source string
+}
+
+// parseMountinfo returns the mounts listed in r, in the format of
+// /proc/<pid>/mountinfo, in mount order.
+func parseMountinfo(r io.Reader) ([]mountEntry, error) {
+	var mounts []mountEntry
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 5 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("malformed mountinfo line '%s'", s.Text())
+		}
+		mounts = append(mounts, mountEntry{dir: mountinfoUnescape.Replace(fields[4]), fstype: fields[sep+1], source: mountinfoUnescape.Replace(fields[sep+2])})
+	}
+	return mounts, s.Err()
+}
+
+func readMountinfo() ([]mountEntry, error) {
+	f, err := os.Open(mountinfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+// mountType returns the type of the filesystem visible at dir, the last
+// one mounted there, or "" if nothing is mounted on dir.
+func mountType(dir string) (string, error) {
+	mounts, err := readMountinfo()
+	if err != nil {
+		return "", err
+	}
+	fstype := ""
+	for _, m := range mounts {
+		if m.dir == dir {
+			fstype = m.fstype
+		}
+	}
+	return fstype, nil
+}
+
+func expectedType(fstype string, overlay bool) bool {
+	if overlay {
+		return fstype == "overlay"
+	}
+	return fstype == "fuse" || strings.HasPrefix(fstype, "fuse.")
+}
+
+// verifyMount checks that dir has just been mounted with the expected
+// filesystem type, and not by something else occupying the path.
+func verifyMount(dir string, overlay bool) (string, error) {
+	fstype, err := mountType(dir)
+	if err != nil {
+		return "", fmt.Errorf("cannot verify mount of '%s': %s", dir, err.Error())
+	}
+	if fstype == "" {
+		return "", fmt.Errorf("'%s' is not mounted", dir)
+	}
+	if !expectedType(fstype, overlay) {
+		return fstype, fmt.Errorf("unexpected filesystem type '%s' mounted on '%s'", fstype, dir)
+	}
+	return fstype, nil
+}
+
+var errVerifyTimeout = errors.New("verification timed out")
+
+// verifyWithin runs verifyMount and checks that the mount answers a stat,
+// giving up after timeout. A timeout of 0 waits without limit.
+func verifyWithin(dir string, overlay bool, timeout time.Duration) (string, error) {
+	type result struct {
+		fstype string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		fstype, err := verifyMount(dir, overlay)
+		if err == nil {
+			if _, serr := os.Stat(dir); serr != nil {
+				err = fmt.Errorf("mount of '%s' does not answer: %s", dir, serr.Error())
+			}
+		}
+		done <- result{fstype, err}
+	}()
+	var expire <-chan time.Time
+	if timeout > 0 {
+		expire = time.After(timeout)
+	}
+	select {
+	case r := <-done:
+		return r.fstype, r.err
+	case <-expire:
+		return "", errVerifyTimeout
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+36 22 0:40 / /var/lib/docker-volumes/objectivefs/a rw,nosuid shared:20 - fuse.objectivefs s3://a rw,user_id=0
+37 22 0:41 / /var/lib/docker-volumes/objectivefs/with\040space rw - fuse objectivefs rw
+38 36 0:42 / /var/lib/docker-volumes/objectivefs/a rw - ext4 /dev/sdb1 rw
+`
+
+// setMountinfo points mountinfoPath at a file holding content.
+func setMountinfo(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := mountinfoPath
+	mountinfoPath = path
+	t.Cleanup(func() { mountinfoPath = old })
+}
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mountEntry{
+		{"/", "ext4", "/dev/sda1"},
+		{"/var/lib/docker-volumes/objectivefs/a", "fuse.objectivefs", "s3://a"},
+		{"/var/lib/docker-volumes/objectivefs/with space", "fuse", "objectivefs"},
+		{"/var/lib/docker-volumes/objectivefs/a", "ext4", "/dev/sdb1"},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("parseMountinfo = %v, want %v", mounts, want)
+	}
+	for _, bad := range []string{"36 22 0:40 / /mnt rw - fuse.objectivefs\n", "36 22 0:40 /\n", "36 22 0:40 / /mnt rw fuse s3://a rw\n"} {
+		if _, err := parseMountinfo(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseMountinfo(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestExpectedType(t *testing.T) {
+	tests := []struct {
+		fstype  string
+		overlay bool
+		want    bool
+	}{
+		{"fuse", false, true},
+		{"fuse.objectivefs", false, true},
+		{"fuseblk", false, false},
+		{"ext4", false, false},
+		{"overlay", true, true},
+		{"fuse.objectivefs", true, false},
+	}
+	for _, tt := range tests {
+		if got := expectedType(tt.fstype, tt.overlay); got != tt.want {
+			t.Errorf("expectedType(%q, %v) = %v, want %v", tt.fstype, tt.overlay, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyMount(t *testing.T) {
+	setMountinfo(t, testMountinfo)
+	// The last mount on a directory is the one visible there.
+	if fstype, err := verifyMount("/var/lib/docker-volumes/objectivefs/a", false); err == nil || fstype != "ext4" {
+		t.Errorf("verifyMount of a shadowing ext4 mount = %q, %v", fstype, err)
+	}
+	if fstype, err := verifyMount("/var/lib/docker-volumes/objectivefs/with space", false); err != nil || fstype != "fuse" {
+		t.Errorf("verifyMount of a fuse mount = %q, %v", fstype, err)
+	}
+	if fstype, err := verifyMount("/var/lib/docker-volumes/objectivefs/b", false); err == nil || fstype != "" {
+		t.Errorf("verifyMount of an unmounted directory = %q, %v", fstype, err)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var niceBinary, _ = exec.LookPath("nice")
+
+// defaultSentinel is the file read by verify_read without verify_read_path.
+const defaultSentinel = ".objectivefs-verify"
+
+var groupRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+var keyVersionRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var prefixRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+var localeRe = regexp.MustCompile(`^(C|POSIX|[a-z]{2,3}(_[A-Z]{2})?)(\.[A-Za-z0-9-]+)?(@[a-z]+)?$`)
+
+// tagKeyRe and tagValueRe follow the tag formats of the major object
+// stores, so tags can be copied to the bucket tags unchanged.
+var tagKeyRe = regexp.MustCompile(`^[A-Za-z0-9_.:/+=@-]{1,128}$`)
+
+var tagValueRe = regexp.MustCompile(`^[A-Za-z0-9 _.:/+=@-]{0,256}$`)
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fuseTimeoutRe is the plain decimal FUSE takes, without the exponents,
+// hex, Inf and NaN spellings strconv.ParseFloat also accepts.
+var fuseTimeoutRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+func validGroup(group, val string) error {
+	if !groupRe.MatchString(group) {
+		return fmt.Errorf("invalid option group name '%s'", group)
+	}
+	if val == "" || strings.ContainsAny(val, " \t\n") || strings.HasPrefix(val, ",") || strings.HasSuffix(val, ",") {
+		return fmt.Errorf("invalid options '%s'", val)
+	}
+	return nil
+}
+
+func setTag(v *ofsVolume, key, val string) error {
+	if !tagKeyRe.MatchString(key) {
+		return fmt.Errorf("invalid tag key '%s'", key)
+	}
+	if !tagValueRe.MatchString(val) {
+		return fmt.Errorf("invalid tag value '%s'", val)
+	}
+	if _, ok := v.tags[key]; ok {
+		return fmt.Errorf("tag '%s' set twice", key)
+	}
+	v.tags[key] = val
+	return nil
+}
+
+func parseBool(val string) (bool, error) {
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean '%s'", val)
+	}
+	return b, nil
+}
+
+// envValue returns the value of the variable key in the environment of
+// v, the last one set like for the mount command.
+func envValue(v *ofsVolume, key string) (string, bool) {
+	for i := len(v.env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(v.env[i], key+"=") {
+			return v.env[i][len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// withPrefix scopes the filesystem fs to an object key prefix within its
+// bucket, e.g. s3://bucket/tenant1.
+func withPrefix(fs, prefix string) string {
+	if strings.Contains(fs, ",") {
+		var fss []string
+		for _, f := range strings.Split(fs, ",") {
+			fss = append(fss, withPrefix(f, prefix))
+		}
+		return strings.Join(fss, ",")
+	}
+	return strings.TrimSuffix(fs, "/") + "/" + prefix
+}
+
+func parseOption(v *ofsVolume, key, val string) error {
+	var err error
+	if strings.HasPrefix(key, "options.") {
+		group := strings.TrimPrefix(key, "options.")
+		if err := validGroup(group, val); err != nil {
+			return err
+		}
+		v.groups[group] = val
+		return nil
+	}
+	if strings.HasPrefix(key, "tag.") {
+		return setTag(v, strings.TrimPrefix(key, "tag."), val)
+	}
+	switch key {
+	case "fs":
+		v.fs = val
+		if strings.Contains(val, ",") {
+			v.layers = strings.Split(val, ",")
+			return validLayers(v.layers)
+		}
+	case "options", "ptions":
+		v.opts = v.opts + "," + val
+	case "attr_timeout", "entry_timeout":
+		t, err := strconv.ParseFloat(val, 64)
+		if err != nil || !fuseTimeoutRe.MatchString(val) || math.IsNaN(t) || t < 0 || t > 3600 {
+			return fmt.Errorf("invalid timeout '%s', must be between 0 and 3600 seconds", val)
+		}
+		if t > 1 {
+			log.Printf("Warning: %s %s of ObjectiveFS Volume '%s' delays changes made by other clients by up to %s seconds", key, val, v.volume.Name, val)
+		}
+		v.fuseTimeouts[key] = val
+		v.opts = v.opts + "," + key + "=" + val
+	case "mount_timeout", "unmount_timeout", "verify_timeout":
+		t, err := time.ParseDuration(val)
+		if err != nil || t < 0 {
+			return fmt.Errorf("invalid timeout '%s'", val)
+		}
+		v.timeouts[strings.TrimSuffix(key, "_timeout")] = t
+	case "cost_tag":
+		return setTag(v, "cost", val)
+	case "asap":
+		v.asap = true
+	case "key_version":
+		if !keyVersionRe.MatchString(val) {
+			return fmt.Errorf("invalid key version '%s'", val)
+		}
+		v.keyVersion = val
+	case "latency_probe":
+		v.probe, err = parseBool(val)
+	case "max_mount_age":
+		if v.maxAge, err = time.ParseDuration(val); err != nil || v.maxAge < time.Minute {
+			return fmt.Errorf("invalid mount age '%s', must be at least 1m", val)
+		}
+	case "verify_read":
+		v.verifyRead, err = parseBool(val)
+	case "verify_read_path":
+		p := filepath.Clean(val)
+		if filepath.IsAbs(p) || p == "." || strings.HasPrefix(p, "../") || p == ".." {
+			return fmt.Errorf("invalid sentinel path '%s', must be relative to the volume root", val)
+		}
+		v.verifyPath = p
+	case "propagation":
+		if val != "rshared" {
+			return fmt.Errorf("invalid propagation '%s', only 'rshared' is supported", val)
+		}
+		v.propagation = val
+	case "on_unhealthy":
+		if strings.TrimSpace(val) == "" {
+			return fmt.Errorf("empty command")
+		}
+		v.onUnhealthy = val
+	case "mount_on_create":
+		v.mountOnCreate, err = parseBool(val)
+	case "cache_device":
+		if !filepath.IsAbs(val) {
+			return fmt.Errorf("'%s' is not an absolute path", val)
+		}
+		v.cacheDevice = filepath.Clean(val)
+	case "prefix":
+		if !prefixRe.MatchString(val) || len(val) > 512 {
+			return fmt.Errorf("invalid object prefix '%s'", val)
+		}
+		v.prefix = val
+	case "nice":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < -20 || n > 19 {
+			return fmt.Errorf("invalid niceness '%s', must be between -20 and 19", val)
+		}
+		if niceBinary == "" {
+			return fmt.Errorf("nice command not found")
+		}
+		v.nice = &n
+	case "dns":
+		ips := strings.Split(val, ",")
+		for _, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid DNS server address '%s'", ip)
+			}
+		}
+		v.dns = ips
+		setEnv(v, "DNSCACHEIP", strings.Join(ips, " "), key)
+	case "tz":
+		if _, err := time.LoadLocation(val); err != nil || val == "" || val == "Local" {
+			return fmt.Errorf("unknown time zone '%s'", val)
+		}
+		setEnv(v, "TZ", val, key)
+	case "locale":
+		if !localeRe.MatchString(val) {
+			return fmt.Errorf("invalid locale '%s'", val)
+		}
+		setEnv(v, "LANG", val, key)
+	default:
+		if !envKeyRe.MatchString(key) {
+			return fmt.Errorf("unknown option, and not a valid environment variable name: use letters, digits and '_', not starting with a digit")
+		}
+		setEnv(v, key, val, key)
+	}
+	return err
+}
+
+// parseOptions applies the volume create options to v. Every option is
+// checked, and all problems found are returned together.
+func parseOptions(v *ofsVolume, opts map[string]string) error {
+	var keys []string
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []string
+	for _, key := range keys {
+		if err := parseOption(v, key, opts[key]); err != nil {
+			errs = append(errs, key+": "+err.Error())
+		}
+	}
+	if v.prefix != "" && len(errs) == 0 {
+		v.fs = withPrefix(v.fs, v.prefix)
+		for i := range v.layers {
+			v.layers[i] = withPrefix(v.layers[i], v.prefix)
+		}
+	}
+	if v.verifyPath != "" && !v.verifyRead {
+		errs = append(errs, "verify_read_path: requires verify_read=true")
+	}
+	if v.verifyPath == "" {
+		v.verifyPath = defaultSentinel
+	}
+	if v.cacheDevice != "" && len(errs) == 0 {
+		if err := setCacheDevice(v); err != nil {
+			errs = append(errs, "cache_device: "+err.Error())
+		}
+	}
+	if _, err := v.optionArgs(); err != nil {
+		errs = append(errs, "options: "+err.Error())
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid options for volume '%s': %s", v.volume.Name, strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestValidGroup(t *testing.T) {
+	tests := []struct {
+		group, val string
+		ok         bool
+	}{
+		{"cache", "ocache", true},
+		{"data_2", "mt,noatime", true},
+		{"Cache", "ocache", false},
+		{"ca-che", "ocache", false},
+		{"", "ocache", false},
+		{"cache", "", false},
+		{"cache", "mt, noatime", false},
+		{"cache", ",mt", false},
+		{"cache", "mt,", false},
+	}
+	for _, tt := range tests {
+		if err := validGroup(tt.group, tt.val); (err == nil) != tt.ok {
+			t.Errorf("validGroup(%q, %q) = %v, want ok %v", tt.group, tt.val, err, tt.ok)
+		}
+	}
+}
+
+func TestParseOptionsAllErrors(t *testing.T) {
+	_, err := newVolume("vol", map[string]string{"fs": "myfs", "nice": "40", "asap": "", "tz": "Nowhere/City", "options.Bad": "mt"}, "")
+	if err == nil {
+		t.Fatal("invalid options accepted")
+	}
+	msg := err.Error()
+	var at []int
+	for _, key := range []string{"nice:", "options.Bad:", "tz:"} {
+		i := strings.Index(msg, key)
+		if i < 0 {
+			t.Errorf("error %q does not report %s", msg, key)
+		}
+		at = append(at, i)
+	}
+	if !strings.HasPrefix(msg, "invalid options for volume 'vol': ") {
+		t.Errorf("error %q does not name the volume", msg)
+	}
+	if at[0] > at[1] || at[1] > at[2] {
+		t.Errorf("error %q does not list the options sorted", msg)
+	}
+}
+
+func TestTimeZoneAndLocale(t *testing.T) {
+	tests := []struct {
+		key, val string
+		env      string
+	}{
+		{"tz", "Europe/Stockholm", "TZ=Europe/Stockholm"},
+		{"tz", "UTC", "TZ=UTC"},
+		{"tz", "Local", ""},
+		{"tz", "Mars/Olympus", ""},
+		{"locale", "en_US.UTF-8", "LANG=en_US.UTF-8"},
+		{"locale", "C", "LANG=C"},
+		{"locale", "sr_RS@latin", "LANG=sr_RS@latin"},
+		{"locale", "en US", ""},
+		{"locale", "../etc", ""},
+	}
+	for _, tt := range tests {
+		v, err := newVolume("vol", map[string]string{"fs": "myfs", tt.key: tt.val}, "")
+		if tt.env == "" {
+			if err == nil {
+				t.Errorf("%s=%q accepted", tt.key, tt.val)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s=%q: %v", tt.key, tt.val, err)
+			continue
+		}
+		if len(v.env) != 1 || v.env[0] != tt.env {
+			t.Errorf("%s=%q: env %q, want %q", tt.key, tt.val, v.env, tt.env)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		opts map[string]string
+		fs   string
+	}{
+		{map[string]string{"fs": "s3://bucket", "prefix": "tenant1"}, "s3://bucket/tenant1"},
+		{map[string]string{"fs": "s3://bucket/", "prefix": "a/b-c.d"}, "s3://bucket/a/b-c.d"},
+		{map[string]string{"fs": "s3://a,s3://b", "prefix": "t"}, "s3://a/t,s3://b/t"},
+		{map[string]string{"fs": "s3://bucket", "prefix": "/tenant"}, ""},
+		{map[string]string{"fs": "s3://bucket", "prefix": "a//b"}, ""},
+		{map[string]string{"fs": "s3://bucket", "prefix": ".."}, ""},
+		{map[string]string{"fs": "s3://bucket", "prefix": "a b"}, ""},
+		{map[string]string{"fs": "s3://bucket", "prefix": strings.Repeat("a", 513)}, ""},
+	}
+	for _, tt := range tests {
+		v, err := newVolume("vol", tt.opts, "")
+		if tt.fs == "" {
+			if err == nil {
+				t.Errorf("prefix %q accepted", tt.opts["prefix"])
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("prefix %q: %v", tt.opts["prefix"], err)
+		} else if v.fs != tt.fs {
+			t.Errorf("prefix %q on %q: fs %q, want %q", tt.opts["prefix"], tt.opts["fs"], v.fs, tt.fs)
+		}
+	}
+}
+
+func TestNice(t *testing.T) {
+	for val, ok := range map[string]bool{"10": true, "-20": true, "19": true, "20": false, "-21": false, "low": false} {
+		v, err := newVolume("vol", map[string]string{"fs": "myfs", "nice": val}, "")
+		if (err == nil) != ok {
+			t.Errorf("nice=%s: %v, want ok %v", val, err, ok)
+		}
+		if ok && err == nil && strconv.Itoa(*v.nice) != val {
+			t.Errorf("nice=%s: niceness %d", val, *v.nice)
+		}
+	}
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "nice": "10"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	base, _ := exec.Command("nice").Output()
+	n, _ := strconv.Atoi(strings.TrimSpace(string(base)))
+	if got, _ := ioutil.ReadFile(filepath.Join(f.dir, "niceness")); strings.TrimSpace(string(got)) != strconv.Itoa(n+10) {
+		t.Errorf("mount helper ran at niceness %q, want %d", got, n+10)
+	}
+}
+
+func TestEnvKeys(t *testing.T) {
+	for key, ok := range map[string]bool{"AWS_PROFILE": true, "_DEBUG": true, "http_proxy": true, "X1": true, "aws.profile": false, "1X": false, "A-B": false, "A B": false, "A=B": false} {
+		v, err := newVolume("vol", map[string]string{"fs": "myfs", key: "val"}, "")
+		if (err == nil) != ok {
+			t.Errorf("env key %q: %v, want ok %v", key, err, ok)
+			continue
+		}
+		if ok && (len(v.env) != 1 || v.env[0] != key+"=val") {
+			t.Errorf("env key %q: env %q", key, v.env)
+		}
+		if !ok && !strings.Contains(err.Error(), "not a valid environment variable name") {
+			t.Errorf("env key %q: unclear error %q", key, err)
+		}
+	}
+}
+
+func TestFuseTimeouts(t *testing.T) {
+	tests := []struct {
+		opts map[string]string
+		want []string
+	}{
+		{map[string]string{"fs": "myfs", "attr_timeout": "1"}, []string{"-oauto,attr_timeout=1", "myfs", "/mnt"}},
+		{map[string]string{"fs": "myfs", "entry_timeout": "0.5", "options": "mt"}, []string{"-oauto,entry_timeout=0.5,mt", "myfs", "/mnt"}},
+		{map[string]string{"fs": "myfs", "attr_timeout": "3600"}, []string{"-oauto,attr_timeout=3600", "myfs", "/mnt"}},
+		{map[string]string{"fs": "myfs", "attr_timeout": "3601"}, nil},
+		{map[string]string{"fs": "myfs", "entry_timeout": "-1"}, nil},
+		{map[string]string{"fs": "myfs", "entry_timeout": "1s"}, nil},
+		{map[string]string{"fs": "myfs", "attr_timeout": "NaN"}, nil},
+		{map[string]string{"fs": "myfs", "attr_timeout": "nan"}, nil},
+		{map[string]string{"fs": "myfs", "attr_timeout": "Inf"}, nil},
+		{map[string]string{"fs": "myfs", "entry_timeout": "-inf"}, nil},
+		{map[string]string{"fs": "myfs", "entry_timeout": "1e1"}, nil},
+		{map[string]string{"fs": "myfs", "entry_timeout": "0x1p-2"}, nil},
+		{map[string]string{"fs": "myfs", "entry_timeout": ".5"}, nil},
+	}
+	for _, tt := range tests {
+		v, err := newVolume("vol", tt.opts, "")
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%v accepted", tt.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.opts, err)
+			continue
+		}
+		if got := mountArgs(v, v.fs, "/mnt"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mountArgs(%v) = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestDNS(t *testing.T) {
+	tests := []struct {
+		val string
+		env string
+	}{
+		{"10.0.0.2", "DNSCACHEIP=10.0.0.2"},
+		{"10.0.0.2,10.0.0.3", "DNSCACHEIP=10.0.0.2 10.0.0.3"},
+		{"fd00::53", "DNSCACHEIP=fd00::53"},
+		{"", ""},
+		{"10.0.0.2,", ""},
+		{"10.0.0.2 10.0.0.3", ""},
+		{"dns.example.com", ""},
+	}
+	d := testDriver(t)
+	for _, tt := range tests {
+		v, err := newVolume("vol", map[string]string{"fs": "myfs", "dns": tt.val}, "")
+		if tt.env == "" {
+			if err == nil {
+				t.Errorf("dns=%q accepted", tt.val)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("dns=%q: %v", tt.val, err)
+			continue
+		}
+		if len(v.env) != 1 || v.env[0] != tt.env {
+			t.Errorf("dns=%q: env %q, want %q", tt.val, v.env, tt.env)
+		}
+		if got := d.status(v)["dns"]; !reflect.DeepEqual(got, v.dns) || len(v.dns) == 0 {
+			t.Errorf("dns=%q: status %v", tt.val, got)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		opts map[string]string
+		want map[string]string
+	}{
+		{map[string]string{"tag.team": "storage"}, map[string]string{"team": "storage"}},
+		{map[string]string{"cost_tag": "cc-1234"}, map[string]string{"cost": "cc-1234"}},
+		{map[string]string{"tag.aws:env": "prod eu", "tag.owner": ""}, map[string]string{"aws:env": "prod eu", "owner": ""}},
+		{map[string]string{"tag.cost": "a", "cost_tag": "b"}, nil},
+		{map[string]string{"tag.": "x"}, nil},
+		{map[string]string{"tag.a b": "x"}, nil},
+		{map[string]string{"tag." + strings.Repeat("k", 129): "x"}, nil},
+		{map[string]string{"tag.team": strings.Repeat("v", 257)}, nil},
+		{map[string]string{"tag.team": "a;b"}, nil},
+	}
+	d := testDriver(t)
+	for _, tt := range tests {
+		opts := map[string]string{"fs": "myfs"}
+		for k, val := range tt.opts {
+			opts[k] = val
+		}
+		v, err := newVolume("vol", opts, "")
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q accepted", tt.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(v.tags, tt.want) {
+			t.Errorf("%q: tags %q, want %q", tt.opts, v.tags, tt.want)
+		}
+		if got := d.status(v)["tags"]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: status tags %v", tt.opts, got)
+		}
+		if args := mountArgs(v, v.fs, "/mnt"); len(v.env) != 0 || !reflect.DeepEqual(args, []string{"-oauto", "myfs", "/mnt"}) {
+			t.Errorf("%q: tags passed to the mount helper %q %q", tt.opts, v.env, args)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// passphraseFile is where ObjectiveFS reads the passphrase from when it is
+// not given in the environment.
+var passphraseFile = "/etc/objectivefs.env/OBJECTIVEFS_PASSPHRASE"
+
+// passphraseEntropy estimates the entropy of p in bits from its length and
+// the character classes it uses.
+func passphraseEntropy(p string) float64 {
+	var lower, upper, digit, other bool
+	for _, c := range p {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(len([]rune(p))) * math.Log2(float64(pool))
+}
+
+// checkPassphrase applies the passphrase policy to the passphrase v would
+// mount with. The passphrase itself is never part of the error.
+func (d *ofsDriver) checkPassphrase(v *ofsVolume) error {
+	if d.config.passphraseMinLength == 0 && d.config.passphraseMinEntropy == 0 {
+		return nil
+	}
+	p, ok := envValue(v, "OBJECTIVEFS_PASSPHRASE")
+	if !ok {
+		b, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil
+		}
+		p = strings.TrimRight(string(b), "\n")
+	}
+	if n := len([]rune(p)); n < d.config.passphraseMinLength {
+		return fmt.Errorf("passphrase of volume '%s' too short: %d characters, at least %d required", v.volume.Name, n, d.config.passphraseMinLength)
+	}
+	if e := passphraseEntropy(p); e < float64(d.config.passphraseMinEntropy) {
+		return fmt.Errorf("passphrase of volume '%s' too weak: about %.0f bits, at least %d required", v.volume.Name, e, d.config.passphraseMinEntropy)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPassphraseEntropy(t *testing.T) {
+	tests := []struct {
+		p    string
+		want float64
+	}{
+		{"", 0},
+		{"abcd", 4 * math.Log2(26)},
+		{"abCD", 4 * math.Log2(52)},
+		{"ab12", 4 * math.Log2(36)},
+		{"aB1!", 4 * math.Log2(95)},
+		{"ééé", 3 * math.Log2(26)},
+	}
+	for _, tt := range tests {
+		if got := passphraseEntropy(tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("passphraseEntropy(%q) = %f, want %f", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassphrase(t *testing.T) {
+	old := passphraseFile
+	passphraseFile = filepath.Join(t.TempDir(), "OBJECTIVEFS_PASSPHRASE")
+	defer func() { passphraseFile = old }()
+	tests := []struct {
+		length, entropy int
+		opts            map[string]string
+		ok              bool
+	}{
+		{0, 0, map[string]string{"fs": "myfs", "OBJECTIVEFS_PASSPHRASE": "a"}, true},
+		{8, 0, map[string]string{"fs": "myfs", "OBJECTIVEFS_PASSPHRASE": "abcdefgh"}, true},
+		{8, 0, map[string]string{"fs": "myfs", "OBJECTIVEFS_PASSPHRASE": "abcdefg"}, false},
+		{0, 60, map[string]string{"fs": "myfs", "OBJECTIVEFS_PASSPHRASE": "aaaaaaaaaaaaaaaa"}, true},
+		{0, 60, map[string]string{"fs": "myfs", "OBJECTIVEFS_PASSPHRASE": "aaaaaaaaaaa"}, false},
+		{8, 0, map[string]string{"fs": "myfs"}, true},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.passphraseMinLength, d.config.passphraseMinEntropy = tt.length, tt.entropy
+		err := d.checkPassphrase(testVolume(t, tt.opts))
+		if (err == nil) != tt.ok {
+			t.Errorf("min length %d, entropy %d, %v: %v", tt.length, tt.entropy, tt.opts, err)
+		}
+		if p := tt.opts["OBJECTIVEFS_PASSPHRASE"]; err != nil && strings.Contains(err.Error(), p) {
+			t.Errorf("error %q shows the passphrase", err)
+		}
+	}
+	// Without one in the environment the passphrase file is checked.
+	if err := ioutil.WriteFile(passphraseFile, []byte("short\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := testDriver(t)
+	d.config.passphraseMinLength = 8
+	if err := d.checkPassphrase(testVolume(t, map[string]string{"fs": "myfs"})); err == nil {
+		t.Error("short passphrase file accepted")
+	}
+}
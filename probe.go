@@ -0,0 +1,91 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// probeFirstByte times the first read of the root directory of a freshly
+// mounted filesystem, which has to be served by the object store. A probe
+// still blocked after timeout is abandoned.
+func probeFirstByte(dir string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(dir)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer f.Close()
+		if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+			done <- err
+			return
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		return time.Since(start), err
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("no response within %s", timeout)
+	}
+}
+
+func (d *ofsDriver) probe(v *ofsVolume) {
+	t, err := probeFirstByte(v.volume.Mountpoint, d.config.probeTimeout)
+	if err != nil {
+		log.Printf("First byte probe of ObjectiveFS Volume '%s' failed: %s", v.volume.Name, err.Error())
+		metrics.inc("objectivefs_probe_failures_total", "volume", v.volume.Name)
+		return
+	}
+	log.Printf("First byte of ObjectiveFS Volume '%s' after %s", v.volume.Name, t)
+	v.firstByte = t
+	metrics.set("objectivefs_first_byte_seconds", t.Seconds(), "volume", v.volume.Name)
+}
+
+// readSentinel reads up to 64KiB of the file at path, which fails on a
+// filesystem mounted with the wrong key, giving up after timeout.
+func readSentinel(path string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer f.Close()
+		_, err = io.CopyN(ioutil.Discard, f, 64<<10)
+		if err == io.EOF {
+			err = nil
+		}
+		done <- err
+	}()
+	var expire <-chan time.Time
+	if timeout > 0 {
+		expire = time.After(timeout)
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expire:
+		return fmt.Errorf("no response within %s", timeout)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestProbe(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs", "latency_probe": "true"})
+	if !v.probe {
+		t.Fatal("latency_probe=true not set")
+	}
+	failures := metrics.get("objectivefs_probe_failures_total", "volume", "vol")
+	d.probe(v)
+	if v.firstByte != 0 || metrics.get("objectivefs_probe_failures_total", "volume", "vol") != failures+1 {
+		t.Errorf("probe of missing mountpoint: first byte %s, failures not counted", v.firstByte)
+	}
+	if err := d.makeMountpoint(v.volume.Mountpoint); err != nil {
+		t.Fatal(err)
+	}
+	d.probe(v)
+	if v.firstByte == 0 {
+		t.Error("probe of mountpoint recorded no latency")
+	}
+	if _, err := probeFirstByte(filepath.Join(t.TempDir(), "gone"), staleTimeout); err == nil {
+		t.Error("probe of missing directory succeeded")
+	}
+}
+
+func TestVerifyReadPath(t *testing.T) {
+	tests := []struct {
+		opts map[string]string
+		path string
+	}{
+		{map[string]string{"fs": "myfs", "verify_read": "true"}, defaultSentinel},
+		{map[string]string{"fs": "myfs", "verify_read": "true", "verify_read_path": "data/./check"}, "data/check"},
+		{map[string]string{"fs": "myfs", "verify_read": "true", "verify_read_path": "/etc/passwd"}, ""},
+		{map[string]string{"fs": "myfs", "verify_read": "true", "verify_read_path": "../x"}, ""},
+		{map[string]string{"fs": "myfs", "verify_read": "true", "verify_read_path": "a/../.."}, ""},
+		{map[string]string{"fs": "myfs", "verify_read": "true", "verify_read_path": "."}, ""},
+		{map[string]string{"fs": "myfs", "verify_read_path": "check"}, ""},
+	}
+	for _, tt := range tests {
+		v, err := newVolume("vol", tt.opts, "")
+		if tt.path == "" {
+			if err == nil {
+				t.Errorf("%v accepted", tt.opts)
+			}
+			continue
+		}
+		if err != nil || v.verifyPath != tt.path {
+			t.Errorf("%v: path %q, %v, want %q", tt.opts, v.verifyPath, err, tt.path)
+		}
+	}
+}
+
+func TestReadSentinel(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sentinel")
+	if err := ioutil.WriteFile(path, make([]byte, 100<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readSentinel(path, time.Second); err != nil {
+		t.Errorf("readSentinel: %v", err)
+	}
+	if err := readSentinel(filepath.Join(dir, "gone"), 0); err == nil {
+		t.Error("readSentinel of a missing file succeeded")
+	}
+	if err := readSentinel(dir, time.Second); err == nil {
+		t.Error("readSentinel of a directory succeeded")
+	}
+}
+
+func TestVerifyRead(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "verify_read": "true"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err == nil {
+		t.Error("Mount without the sentinel succeeded")
+	}
+	v := d.volumes["vol"]
+	if v.mounted || f.mounts() != 0 {
+		t.Errorf("rejected mount left mounted %v, %d mounts", v.mounted, f.mounts())
+	}
+	if err := os.MkdirAll(v.volume.Mountpoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(v.volume.Mountpoint, defaultSentinel), []byte("ok"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Errorf("Mount with the sentinel: %v", err)
+	}
+}
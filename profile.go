@@ -0,0 +1,78 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// profilesFile maps profile names to the create options they stand for.
+type profilesFile struct {
+	Profiles map[string]map[string]string `json:"profiles"`
+}
+
+type ofsProfiles struct {
+	sync.RWMutex
+	sets map[string]map[string]string
+}
+
+var profiles = &ofsProfiles{}
+
+func (p *ofsProfiles) load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f profilesFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("invalid profiles file '%s': %s", path, err.Error())
+	}
+	for name, opts := range f.Profiles {
+		if _, ok := opts["profile"]; ok {
+			return fmt.Errorf("invalid profiles file '%s': profile '%s' refers to another profile", path, name)
+		}
+	}
+	p.Lock()
+	p.sets = f.Profiles
+	p.Unlock()
+	return nil
+}
+
+// expand returns opts with the options of the profile it selects added
+// below them, so options given explicitly win.
+func (p *ofsProfiles) expand(opts map[string]string) (map[string]string, error) {
+	name, ok := opts["profile"]
+	if !ok {
+		return opts, nil
+	}
+	p.RLock()
+	set, ok := p.sets[name]
+	p.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown profile '%s'", name)
+	}
+	all := make(map[string]string, len(set)+len(opts))
+	for key, val := range set {
+		all[key] = val
+	}
+	for key, val := range opts {
+		if key != "profile" {
+			all[key] = val
+		}
+	}
+	return all, nil
+}
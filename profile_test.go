@@ -0,0 +1,77 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfilesLoad(t *testing.T) {
+	tests := []struct {
+		content string
+		ok      bool
+	}{
+		{`{"profiles": {"fast": {"options": "mt"}}}`, true},
+		{`{"profiles": {}}`, true},
+		{`{"profiles": {"a": {"profile": "b"}, "b": {}}}`, false},
+		{`{"profiles": [`, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "profiles.json")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&ofsProfiles{}).load(path); (err == nil) != tt.ok {
+			t.Errorf("load(%q): %v, want ok %v", tt.content, err, tt.ok)
+		}
+	}
+	if err := (&ofsProfiles{}).load(filepath.Join(t.TempDir(), "gone")); err == nil {
+		t.Error("load of a missing file succeeded")
+	}
+}
+
+func TestProfilesExpand(t *testing.T) {
+	p := &ofsProfiles{sets: map[string]map[string]string{
+		"fast":    {"options": "mt", "CACHESIZE": "2G"},
+		"archive": {"options": "noatime", "asap": ""},
+	}}
+	tests := []struct {
+		opts map[string]string
+		want map[string]string
+	}{
+		{map[string]string{"fs": "a"}, map[string]string{"fs": "a"}},
+		{map[string]string{"fs": "a", "profile": "fast"}, map[string]string{"fs": "a", "options": "mt", "CACHESIZE": "2G"}},
+		{map[string]string{"fs": "a", "profile": "fast", "CACHESIZE": "1G"}, map[string]string{"fs": "a", "options": "mt", "CACHESIZE": "1G"}},
+		{map[string]string{"fs": "a", "profile": "archive"}, map[string]string{"fs": "a", "options": "noatime", "asap": ""}},
+		{map[string]string{"fs": "a", "profile": "slow"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := p.expand(tt.opts)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("expand(%v) accepted an unknown profile", tt.opts)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expand(%v) = %v, %v, want %v", tt.opts, got, err, tt.want)
+		}
+	}
+	if _, ok := p.sets["fast"]["fs"]; ok {
+		t.Error("expand changed the profile")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// checkPropagation refuses shared propagation unless it is enabled, since
+// it makes the mounts of the volume appear in every mount namespace that
+// shares the mount root.
+func (d *ofsDriver) checkPropagation(v *ofsVolume) error {
+	if v.propagation != "" && !d.config.allowSharedPropagation {
+		return fmt.Errorf("invalid options for volume '%s': propagation: shared propagation is disabled, set OBJECTIVEFS_ALLOW_SHARED_PROPAGATION=true", v.volume.Name)
+	}
+	return nil
+}
+
+// sharedRootCommands returns the commands that make root a shared subtree.
+// A directory that is not a mount point is bind mounted on itself first,
+// since only mounts have a propagation type. The bind is recursive so the
+// volumes already mounted below root stay visible through it.
+func sharedRootCommands(root string, isMount bool) [][]string {
+	var cmds [][]string
+	if !isMount {
+		cmds = append(cmds, []string{"mount", "--rbind", root, root})
+	}
+	return append(cmds, []string{"mount", "--make-rshared", root})
+}
+
+func (d *ofsDriver) runMount(args []string, timeout time.Duration) error {
+	out, err := run(d.config.command(args[0], args[1:]...), timeout)
+	if err == errCommandTimeout {
+		out = fmt.Sprintf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("'%s' failed: %s", strings.Join(args, " "), strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// shareRoot makes the mount root a shared subtree, so the mounts below it
+// propagate to the nested mount namespaces created from it. It runs once
+// at startup, before any volume is mounted: binding the root over mounts
+// already below it would stack copies of them that an unmount of the
+// volume does not remove. Each command gets timeout.
+func (d *ofsDriver) shareRoot(timeout time.Duration) error {
+	if err := os.MkdirAll(mountRoot, 0755); err != nil {
+		return err
+	}
+	mounts, err := readMountinfo()
+	if err != nil {
+		return err
+	}
+	isMount := false
+	for _, m := range mounts {
+		if m.dir == mountRoot {
+			isMount = true
+		}
+	}
+	if !isMount {
+		for _, m := range mounts {
+			if strings.HasPrefix(m.dir, mountRoot+"/") {
+				return fmt.Errorf("'%s' is mounted below it, unmount it first", m.dir)
+			}
+		}
+	}
+	for _, args := range sharedRootCommands(mountRoot, isMount) {
+		if err := d.runMount(args, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shareMount marks the mount of v as rshared, so mounts made below it
+// propagate as well.
+func (d *ofsDriver) shareMount(v *ofsVolume) error {
+	if err := d.runMount([]string{"mount", "--make-rshared", v.volume.Mountpoint}, d.timeout(v, "mount")); err != nil {
+		return err
+	}
+	log.Printf("ObjectiveFS Volume '%s' mounted with rshared propagation", v.volume.Name)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedRootCommands(t *testing.T) {
+	tests := []struct {
+		isMount bool
+		want    [][]string
+	}{
+		{false, [][]string{{"mount", "--rbind", "/r", "/r"}, {"mount", "--make-rshared", "/r"}}},
+		{true, [][]string{{"mount", "--make-rshared", "/r"}}},
+	}
+	for _, tt := range tests {
+		if got := sharedRootCommands("/r", tt.isMount); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sharedRootCommands(%v) = %q, want %q", tt.isMount, got, tt.want)
+		}
+	}
+}
+
+func TestShareRoot(t *testing.T) {
+	tests := []struct {
+		mounted []string
+		calls   []string
+		ok      bool
+	}{
+		{nil, []string{"mount --rbind @ROOT@ @ROOT@", "mount --make-rshared @ROOT@"}, true},
+		{[]string{"@ROOT@"}, []string{"mount --make-rshared @ROOT@"}, true},
+		{[]string{"@ROOT@", "@ROOT@/vol"}, []string{"mount --make-rshared @ROOT@"}, true},
+		{[]string{"@ROOT@/vol"}, nil, false},
+		{[]string{"@ROOT@-other"}, []string{"mount --rbind @ROOT@ @ROOT@", "mount --make-rshared @ROOT@"}, true},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		f := fakeMounts(t, d)
+		var info string
+		for _, dir := range tt.mounted {
+			info += "1 1 0:1 / " + strings.Replace(dir, "@ROOT@", mountRoot, -1) + " rw - fuse.objectivefs objectivefs rw\n"
+		}
+		if err := ioutil.WriteFile(filepath.Join(f.dir, "mountinfo"), []byte(info), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := d.shareRoot(time.Minute)
+		if (err == nil) != tt.ok {
+			t.Errorf("mounted %q: %v, want ok %v", tt.mounted, err, tt.ok)
+		}
+		var want []string
+		for _, c := range tt.calls {
+			want = append(want, strings.Replace(c, "@ROOT@", mountRoot, -1))
+		}
+		if got := f.calls(); !reflect.DeepEqual(got, want) {
+			t.Errorf("mounted %q: ran %q, want %q", tt.mounted, got, want)
+		}
+	}
+}
+
+func TestCheckPropagation(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs", "propagation": "rshared"})
+	if err := d.checkPropagation(v); err == nil || !strings.Contains(err.Error(), "OBJECTIVEFS_ALLOW_SHARED_PROPAGATION") {
+		t.Errorf("shared propagation allowed by default: %v", err)
+	}
+	d.config.allowSharedPropagation = true
+	if err := d.checkPropagation(v); err != nil {
+		t.Errorf("shared propagation refused when enabled: %v", err)
+	}
+	if err := d.checkPropagation(testVolume(t, map[string]string{"fs": "myfs"})); err != nil {
+		t.Errorf("volume without propagation: %v", err)
+	}
+}
+
+func TestShareMountTimeout(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	v := testVolume(t, map[string]string{"fs": "myfs", "propagation": "rshared", "mount_timeout": "200ms"})
+	f.fail(t, "mount-hang", "hang")
+	start := time.Now()
+	if err := d.shareMount(v); err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("hung rshared mount: %v", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("hung rshared mount took %s", took)
+	}
+}
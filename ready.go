@@ -0,0 +1,95 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Driver modes set through the admin API.
+const (
+	modeNormal      = "normal"
+	modeDrain       = "drain"
+	modeMaintenance = "maintenance"
+)
+
+// notReadyError is returned for requests the driver cannot serve right
+// now but may serve later, so callers should retry.
+type notReadyError struct {
+	state      string
+	retryAfter time.Duration
+}
+
+func (e *notReadyError) Error() string {
+	return fmt.Sprintf("objectivefs driver not ready (%s), try again later", e.state)
+}
+
+// ready returns a notReadyError while the driver is in its startup grace
+// period, or in a mode that does not allow the operation. Drain refuses
+// new creates and mounts, maintenance also refuses removes.
+func (d *ofsDriver) ready(op string) error {
+	if left := startTime.Add(d.config.startupGrace).Sub(time.Now()); left > 0 {
+		return &notReadyError{"starting", left}
+	}
+	d.RLock()
+	mode := d.mode
+	d.RUnlock()
+	switch {
+	case mode == modeMaintenance:
+		return &notReadyError{"maintenance", time.Minute}
+	case mode == modeDrain && op != "remove":
+		return &notReadyError{"draining", time.Minute}
+	}
+	return nil
+}
+
+// errorStatus maps err to the HTTP status of an admin API response, with
+// 503 for errors worth retrying.
+func errorStatus(w http.ResponseWriter, err error, status int) int {
+	if e, ok := err.(*notReadyError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(e.retryAfter.Seconds()+0.5)))
+		return http.StatusServiceUnavailable
+	}
+	return status
+}
+
+func (d *ofsDriver) setMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		d.RLock()
+		mode := d.mode
+		d.RUnlock()
+		writeJSON(w, http.StatusOK, map[string]string{"mode": mode})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	mode := r.URL.Query().Get("set")
+	switch mode {
+	case modeNormal, modeDrain, modeMaintenance:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Err": "invalid mode '" + mode + "'"})
+		return
+	}
+	d.Lock()
+	d.mode = mode
+	d.Unlock()
+	log.Printf("ObjectiveFS Volume Driver in %s mode", mode)
+	writeJSON(w, http.StatusOK, map[string]string{"mode": mode})
+}
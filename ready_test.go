@@ -0,0 +1,105 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		mode  string
+		grace time.Duration
+		op    string
+		state string
+	}{
+		{modeNormal, 0, "mount", ""},
+		{modeNormal, time.Hour, "remove", "starting"},
+		{modeDrain, 0, "create", "draining"},
+		{modeDrain, 0, "mount", "draining"},
+		{modeDrain, 0, "remove", ""},
+		{modeMaintenance, 0, "remove", "maintenance"},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.mode, d.config.startupGrace = tt.mode, tt.grace
+		err := d.ready(tt.op)
+		var nr *notReadyError
+		if tt.state == "" {
+			if err != nil {
+				t.Errorf("%s, grace %s, %s: %v", tt.mode, tt.grace, tt.op, err)
+			}
+			continue
+		}
+		if !errors.As(err, &nr) || nr.state != tt.state || nr.retryAfter <= 0 {
+			t.Errorf("%s, grace %s, %s: %v, want not ready (%s)", tt.mode, tt.grace, tt.op, err, tt.state)
+		}
+	}
+}
+
+func TestNotReadyRequests(t *testing.T) {
+	d := testDriver(t)
+	d.mode = modeDrain
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err == nil || err.Error() != "objectivefs driver not ready (draining), try again later" {
+		t.Errorf("Create while draining: %v", err)
+	}
+	if len(d.volumes) != 0 {
+		t.Error("volume created while draining")
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{&notReadyError{"starting", 1400 * time.Millisecond}, http.StatusServiceUnavailable, "1"},
+		{&notReadyError{"draining", time.Minute}, http.StatusServiceUnavailable, "60"},
+		{errors.New("volume 'vol' not found"), http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if got := errorStatus(w, tt.err, http.StatusNotFound); got != tt.status || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("errorStatus(%v) = %d, Retry-After %q, want %d, %q", tt.err, got, w.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+		}
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	d := testDriver(t)
+	for _, tt := range []struct {
+		method, query string
+		status        int
+		mode          string
+	}{
+		{"GET", "", http.StatusOK, modeNormal},
+		{"POST", "set=drain", http.StatusOK, modeDrain},
+		{"POST", "set=off", http.StatusBadRequest, modeDrain},
+		{"PUT", "set=normal", http.StatusNotFound, modeDrain},
+		{"POST", "set=normal", http.StatusOK, modeNormal},
+	} {
+		w := httptest.NewRecorder()
+		d.setMode(w, httptest.NewRequest(tt.method, "/mode?"+tt.query, nil))
+		if w.Code != tt.status || d.mode != tt.mode {
+			t.Errorf("%s /mode?%s: %d, mode %s, want %d, %s", tt.method, tt.query, w.Code, d.mode, tt.status, tt.mode)
+		}
+	}
+}
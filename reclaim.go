@@ -0,0 +1,152 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type reclaimed struct {
+	Dir    string `json:"dir"`
+	Action string `json:"action"`
+	Err    string `json:"error,omitempty"`
+}
+
+// ownedBy tells if dir is the mountpoint or a layer directory of one of
+// the volumes in names.
+func ownedBy(dir string, names map[string]bool) bool {
+	if rel, err := filepath.Rel(layerRoot(), dir); err == nil && !strings.HasPrefix(rel, "..") {
+		return names[strings.SplitN(rel, "/", 2)[0]]
+	}
+	return names[filepath.Base(dir)] && filepath.Dir(dir) == mountRoot
+}
+
+// orphanDirs returns the directories below the mount root, and below the
+// layer root, that belong to no volume in names.
+func orphanDirs(names map[string]bool) []string {
+	var dirs []string
+	for _, root := range []string{mountRoot, layerRoot()} {
+		fis, _ := ioutil.ReadDir(root)
+		for _, fi := range fis {
+			dir := filepath.Join(root, fi.Name())
+			if fi.IsDir() && dir != layerRoot() && !ownedBy(dir, names) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// reclaim looks for the mounts and mountpoints below the mount root left
+// behind by volumes the driver no longer knows, e.g. after a remove that
+// failed halfway, and with OBJECTIVEFS_ORPHAN_POLICY=remove unmounts and
+// removes them. Others are only reported.
+func (d *ofsDriver) reclaim() []reclaimed {
+	names := make(map[string]bool)
+	d.RLock()
+	for name := range d.volumes {
+		names[name] = true
+	}
+	d.RUnlock()
+	res := []reclaimed{}
+	mounts, err := readMountinfo()
+	if err != nil {
+		log.Printf("Reclaim: %s", err.Error())
+		return res
+	}
+	deps := make(map[string][]string)
+	for _, m := range mounts {
+		if strings.HasPrefix(m.dir, mountRoot+"/") && !ownedBy(m.dir, names) {
+			deps[m.dir] = nil
+		}
+	}
+	busy := make(map[string]bool)
+	for _, dir := range unmountOrder(deps) {
+		r := reclaimed{Dir: dir, Action: "kept"}
+		if d.config.orphanPolicy == "remove" {
+			r.Action = "unmounted"
+			if err := d.umountDir(dir, d.config.unmountPolicy, d.config.timeouts["unmount"]); err != nil {
+				r.Action, r.Err = "failed", err.Error()
+				busy[dir] = true
+			}
+		}
+		log.Printf("Reclaim: orphaned mount '%s' %s", dir, r.Action)
+		res = append(res, r)
+	}
+	// A directory is only removed once nothing is mounted on or below it,
+	// as removing entries of a mounted directory deletes files of the
+	// filesystem.
+	if mounts, err = readMountinfo(); err != nil {
+		log.Printf("Reclaim: %s", err.Error())
+		return res
+	}
+	for _, m := range mounts {
+		busy[m.dir] = true
+	}
+	dirs := orphanDirs(names)
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		r := reclaimed{Dir: dir, Action: "kept"}
+		if d.config.orphanPolicy == "remove" {
+			r.Action = "removed"
+			if err := removeOrphan(dir, busy); err != nil {
+				r.Action, r.Err = "failed", err.Error()
+			}
+		}
+		log.Printf("Reclaim: orphaned mountpoint '%s' %s", dir, r.Action)
+		res = append(res, r)
+	}
+	return res
+}
+
+// removeOrphan removes the orphaned mountpoint dir if it is empty, unless
+// it or a directory below it is in busy. A layer directory may still hold
+// the empty mountpoints of its layers, which are removed first.
+func removeOrphan(dir string, busy map[string]bool) error {
+	for b := range busy {
+		if b == dir || strings.HasPrefix(b, dir+"/") {
+			return fmt.Errorf("'%s' still mounted", b)
+		}
+	}
+	if filepath.Dir(dir) == layerRoot() {
+		subs, _ := ioutil.ReadDir(dir)
+		for _, sub := range subs {
+			if _, err := strconv.ParseUint(sub.Name(), 10, 32); err == nil && sub.IsDir() {
+				os.Remove(filepath.Join(dir, sub.Name()))
+			}
+		}
+	}
+	return os.Remove(dir)
+}
+
+// reconcileHandler reconciles the declared volumes, if any, and reclaims
+// orphaned mountpoints.
+func (d *ofsDriver) reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if d.config.volumesFile != "" {
+		d.reconcile()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reclaimed": d.reclaim()})
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestOwnedBy(t *testing.T) {
+	testDriver(t)
+	names := map[string]bool{"vol": true}
+	for dir, want := range map[string]bool{
+		filepath.Join(mountRoot, "vol"):              true,
+		filepath.Join(mountRoot, "other"):            false,
+		filepath.Join(mountRoot, "vol", "sub"):       false,
+		filepath.Join(layerRoot(), "vol"):            true,
+		filepath.Join(layerRoot(), "vol", "0"):       true,
+		filepath.Join(layerRoot(), "other", "0"):     false,
+		filepath.Join(mountRoot+"-elsewhere", "vol"): false,
+	} {
+		if got := ownedBy(dir, names); got != want {
+			t.Errorf("ownedBy(%q) = %v, want %v", dir, got, want)
+		}
+	}
+}
+
+// reclaimTree makes the mount root hold, besides the volume vol:
+//   - gone, an orphaned mountpoint still mounted, with a file on the mount
+//   - empty, an orphaned empty mountpoint
+//   - kept, an orphaned mountpoint holding a file
+//   - .layers/old with the empty layer mountpoints 0 and 1 and a file
+//   - .layers/stale with the empty layer mountpoint 0, still mounted
+func reclaimTree(t *testing.T, d *ofsDriver) {
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"vol", "gone", "empty", "kept", ".layers/old/0", ".layers/old/1", ".layers/stale/0"} {
+		if err := os.MkdirAll(filepath.Join(mountRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"vol/data", "gone/data", "kept/data", ".layers/old/notes"} {
+		if err := ioutil.WriteFile(filepath.Join(mountRoot, file), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mounts string
+	for _, dir := range []string{"vol", "gone", ".layers/stale/0"} {
+		mounts += "1 1 0:1 / " + filepath.Join(mountRoot, dir) + " rw - fuse.objectivefs objectivefs rw\n"
+	}
+	if err := ioutil.WriteFile(mountinfoPath, []byte(mounts), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func reclaimResult(res []reclaimed) []string {
+	var got []string
+	for _, r := range res {
+		rel, _ := filepath.Rel(mountRoot, r.Dir)
+		got = append(got, rel+" "+r.Action)
+	}
+	sort.Strings(got)
+	return got
+}
+
+// left returns the files and directories left below the mount root.
+func left(t *testing.T) []string {
+	var paths []string
+	filepath.Walk(mountRoot, func(path string, fi os.FileInfo, err error) error {
+		if rel, _ := filepath.Rel(mountRoot, path); rel != "." {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	return paths
+}
+
+func TestReclaim(t *testing.T) {
+	tests := []struct {
+		policy string
+		busy   bool
+		res    []string
+		left   []string
+	}{
+		{"keep", false,
+			[]string{".layers/old kept", ".layers/stale kept", ".layers/stale/0 kept", "empty kept", "gone kept", "gone kept", "kept kept"},
+			[]string{".layers", ".layers/old", ".layers/old/0", ".layers/old/1", ".layers/old/notes", ".layers/stale", ".layers/stale/0", "empty", "gone", "gone/data", "kept", "kept/data", "vol", "vol/data"}},
+		{"remove", true,
+			[]string{".layers/old failed", ".layers/stale failed", ".layers/stale/0 failed", "empty removed", "gone failed", "gone failed", "kept failed"},
+			[]string{".layers", ".layers/old", ".layers/old/notes", ".layers/stale", ".layers/stale/0", "gone", "gone/data", "kept", "kept/data", "vol", "vol/data"}},
+		{"remove", false,
+			[]string{".layers/old failed", ".layers/stale removed", ".layers/stale/0 unmounted", "empty removed", "gone failed", "gone unmounted", "kept failed"},
+			[]string{".layers", ".layers/old", ".layers/old/notes", "gone", "gone/data", "kept", "kept/data", "vol", "vol/data"}},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		f := fakeMounts(t, d)
+		d.config.orphanPolicy = tt.policy
+		reclaimTree(t, d)
+		if tt.busy {
+			f.fail(t, "umount-fail", "busy")
+		}
+		if got := reclaimResult(d.reclaim()); !reflect.DeepEqual(got, tt.res) {
+			t.Errorf("%s, busy %v: reclaimed %q, want %q", tt.policy, tt.busy, got, tt.res)
+		}
+		if got := left(t); !reflect.DeepEqual(got, tt.left) {
+			t.Errorf("%s, busy %v: left %q, want %q", tt.policy, tt.busy, got, tt.left)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var procRoot = "/proc"
+
+// clockTicks is USER_HZ, which is 100 on every Linux platform Docker runs on.
+const clockTicks = 100
+
+type procUsage struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	RSSBytes   uint64  `json:"rss_bytes"`
+}
+
+type resourceUsage struct {
+	Plugin    procUsage `json:"plugin"`
+	Processes int       `json:"mount_processes"`
+	Mounts    procUsage `json:"mounts"`
+}
+
+// parseStat returns the user plus system CPU time of a /proc/<pid>/stat.
+func parseStat(data []byte) (float64, error) {
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat")
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed stat")
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("malformed stat")
+	}
+	return float64(utime+stime) / clockTicks, nil
+}
+
+// parseRSS returns the resident set size of a /proc/<pid>/status.
+func parseRSS(data []byte) uint64 {
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func readUsage(dir string) (procUsage, error) {
+	var u procUsage
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return u, err
+	}
+	if u.CPUSeconds, err = parseStat(stat); err != nil {
+		return u, err
+	}
+	status, err := ioutil.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return u, err
+	}
+	u.RSSBytes = parseRSS(status)
+	return u, nil
+}
+
+// isMountProcess tells if a /proc/<pid>/cmdline belongs to an ObjectiveFS
+// process serving a mount below the plugin mount root.
+func isMountProcess(cmdline []byte) bool {
+	args := strings.Split(string(cmdline), "\x00")
+	if len(args) == 0 || !strings.Contains(filepath.Base(args[0]), "objectivefs") {
+		return false
+	}
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, mountRoot+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func collectUsage() (*resourceUsage, error) {
+	var ru resourceUsage
+	var err error
+	if ru.Plugin, err = readUsage(filepath.Join(procRoot, "self")); err != nil {
+		return nil, err
+	}
+	dirs, err := filepath.Glob(filepath.Join(procRoot, "[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil || !isMountProcess(cmdline) {
+			continue
+		}
+		u, err := readUsage(dir)
+		if err != nil {
+			continue
+		}
+		ru.Processes++
+		ru.Mounts.CPUSeconds += u.CPUSeconds
+		ru.Mounts.RSSBytes += u.RSSBytes
+	}
+	return &ru, nil
+}
+
+func (d *ofsDriver) resources(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		ru  *resourceUsage
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ru, err := collectUsage()
+		done <- result{ru, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"Err": res.err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, res.ru)
+	case <-time.After(5 * time.Second):
+		writeJSON(w, http.StatusGatewayTimeout, map[string]string{"Err": "timed out reading /proc"})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testStat = "1234 (mount.objectivefs (x)) S 1 1234 1234 0 -1 4194560 1000 0 0 0 250 50 0 0 20 0 9 0 100 200000000 5000 18446744073709551615\n"
+
+func TestParseStat(t *testing.T) {
+	if got, err := parseStat([]byte(testStat)); err != nil || got != 3 {
+		t.Errorf("parseStat = %g, %v, want 3", got, err)
+	}
+	for _, bad := range []string{"", "1234 (x) S 1 2", "1234 (x) S 1 1234 1234 0 -1 4194560 1000 0 0 0 a 50"} {
+		if _, err := parseStat([]byte(bad)); err == nil {
+			t.Errorf("parseStat(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseRSS(t *testing.T) {
+	if got := parseRSS([]byte("Name:\tx\nVmPeak:\t 9000 kB\nVmRSS:\t  2048 kB\n")); got != 2048*1024 {
+		t.Errorf("parseRSS = %d, want %d", got, 2048*1024)
+	}
+	if got := parseRSS([]byte("Name:\tkthreadd\n")); got != 0 {
+		t.Errorf("parseRSS without VmRSS = %d", got)
+	}
+}
+
+func TestIsMountProcess(t *testing.T) {
+	testDriver(t)
+	tests := []struct {
+		cmdline string
+		want    bool
+	}{
+		{"/sbin/mount.objectivefs\x00-oauto\x00s3://a\x00" + mountRoot + "/a\x00", true},
+		{"objectivefs\x00mount\x00s3://a\x00" + mountRoot + "/.layers/a/0\x00", true},
+		{"/sbin/mount.objectivefs\x00s3://a\x00/mnt/elsewhere\x00", false},
+		{"/bin/bash\x00" + mountRoot + "/a\x00", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isMountProcess([]byte(tt.cmdline)); got != tt.want {
+			t.Errorf("isMountProcess(%q) = %v, want %v", tt.cmdline, got, tt.want)
+		}
+	}
+}
+
+func TestCollectUsage(t *testing.T) {
+	testDriver(t)
+	root := t.TempDir()
+	proc := func(name, cmdline string) {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for file, content := range map[string]string{"stat": testStat, "status": "VmRSS:\t1024 kB\n", "cmdline": cmdline} {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	proc("self", "/plugin\x00")
+	proc("10", "/sbin/mount.objectivefs\x00s3://a\x00"+mountRoot+"/a\x00")
+	proc("11", "/sbin/mount.objectivefs\x00s3://b\x00"+mountRoot+"/b\x00")
+	proc("12", "/bin/sh\x00")
+	old := procRoot
+	procRoot = root
+	defer func() { procRoot = old }()
+	ru, err := collectUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ru.Processes != 2 || ru.Mounts.CPUSeconds != 6 || ru.Mounts.RSSBytes != 2<<20 || ru.Plugin.RSSBytes != 1<<20 {
+		t.Errorf("usage %+v", ru)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mountDeps returns the mounts of v, each with the mounts it is built on.
+func (v *ofsVolume) mountDeps() map[string][]string {
+	deps := map[string][]string{v.volume.Mountpoint: nil}
+	for i := range v.layers {
+		dir := layerDir(v, i)
+		deps[v.volume.Mountpoint] = append(deps[v.volume.Mountpoint], dir)
+		deps[dir] = nil
+	}
+	return deps
+}
+
+// unmountOrder orders the mount directories of deps so that every mount
+// comes before the mounts it depends on: an overlay before its layers,
+// and a mount before the mount its directory is on.
+func unmountOrder(deps map[string][]string) []string {
+	edges := make(map[string][]string)
+	var dirs []string
+	for dir, on := range deps {
+		dirs = append(dirs, dir)
+		edges[dir] = append(edges[dir], on...)
+	}
+	sort.Strings(dirs)
+	for _, a := range dirs {
+		for _, b := range dirs {
+			if strings.HasPrefix(b, a+"/") {
+				edges[b] = append(edges[b], a)
+			}
+		}
+	}
+	// Post-order puts every mount after the mounts it depends on; the
+	// unmount order is the reverse.
+	var order []string
+	seen := make(map[string]bool)
+	var visit func(string)
+	visit = func(dir string) {
+		if seen[dir] {
+			return
+		}
+		seen[dir] = true
+		on := edges[dir]
+		sort.Strings(on)
+		for _, o := range on {
+			visit(o)
+		}
+		order = append(order, dir)
+	}
+	for _, dir := range dirs {
+		visit(dir)
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+type unmountResult struct {
+	Volume string `json:"volume"`
+	Err    string `json:"error,omitempty"`
+}
+
+// unmountGroups splits the mounted volumes into groups whose mounts do not
+// depend on the mounts of another group, so the groups can be unmounted
+// independently. Every volume is normally a group of its own.
+func unmountGroups(dirs map[string][]string) [][]string {
+	var names []string
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	group := make(map[string]string)
+	var find func(string) string
+	find = func(n string) string {
+		if g, ok := group[n]; ok && g != n {
+			return find(g)
+		}
+		return n
+	}
+	for _, a := range names {
+		for _, b := range names {
+			if a == b {
+				continue
+			}
+			for _, da := range dirs[a] {
+				for _, db := range dirs[b] {
+					if strings.HasPrefix(db, da+"/") {
+						group[find(b)] = find(a)
+					}
+				}
+			}
+		}
+	}
+	byRoot := make(map[string][]string)
+	var roots []string
+	for _, n := range names {
+		r := find(n)
+		if _, ok := byRoot[r]; !ok {
+			roots = append(roots, r)
+		}
+		byRoot[r] = append(byRoot[r], n)
+	}
+	var groups [][]string
+	for _, r := range roots {
+		groups = append(groups, byRoot[r])
+	}
+	return groups
+}
+
+// unmountAll unmounts every mounted volume and reports the outcome per
+// volume. Independent volumes are unmounted concurrently, each group under
+// the locks of its own volumes. Within a group mounts are unmounted
+// before the mounts they depend on; a mount that fails is skipped over,
+// and only the mounts it depends on are left alone.
+func (d *ofsDriver) unmountAll(policy string) []unmountResult {
+	vols := make(map[string]*ofsVolume)
+	dirs := make(map[string][]string)
+	for _, v := range d.snapshot() {
+		v.Lock()
+		if v.mounted && !v.removed {
+			vols[v.volume.Name] = v
+			for dir := range v.mountDeps() {
+				dirs[v.volume.Name] = append(dirs[v.volume.Name], dir)
+			}
+		}
+		v.Unlock()
+	}
+	results := make(chan unmountResult, len(vols))
+	var wg sync.WaitGroup
+	for _, group := range unmountGroups(dirs) {
+		wg.Add(1)
+		go func(group []string) {
+			defer wg.Done()
+			var locked []*ofsVolume
+			for _, name := range group {
+				vols[name].Lock()
+				locked = append(locked, vols[name])
+			}
+			for _, res := range d.unmountGroup(locked, policy) {
+				results <- res
+			}
+			for _, v := range locked {
+				v.Unlock()
+			}
+		}(group)
+	}
+	wg.Wait()
+	close(results)
+	var all []unmountResult
+	for res := range results {
+		all = append(all, res)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Volume < all[j].Volume })
+	return all
+}
+
+// unmountGroup unmounts a group of volumes, which are locked.
+func (d *ofsDriver) unmountGroup(vols []*ofsVolume, policy string) []unmountResult {
+	deps := make(map[string][]string)
+	owner := make(map[string]*ofsVolume)
+	var mounted []*ofsVolume
+	for _, v := range vols {
+		if v.mounted && !v.removed {
+			mounted = append(mounted, v)
+			if v.warm != nil {
+				v.warm.cancel()
+			}
+			for dir, on := range v.mountDeps() {
+				deps[dir], owner[dir] = on, v
+			}
+		}
+	}
+	order := unmountOrder(deps)
+	failed := make(map[*ofsVolume]string)
+	blocked := make(map[string]string)
+	for _, dir := range order {
+		v := owner[dir]
+		if by, ok := blocked[dir]; ok {
+			if _, ok := failed[v]; !ok {
+				failed[v] = "'" + by + "' still mounted"
+			}
+			for _, on := range deps[dir] {
+				blocked[on] = by
+			}
+			continue
+		}
+		d.syncForUnmount(v, dir)
+		log.Printf("Unmount '%s'", dir)
+		if err := d.umountDir(dir, policy, d.timeout(v, "unmount")); err != nil {
+			if _, ok := failed[v]; !ok {
+				failed[v] = fmt.Sprintf("unmount of '%s' failed: %s", dir, err.Error())
+			}
+			for _, on := range deps[dir] {
+				blocked[on] = dir
+			}
+			for _, other := range order {
+				if strings.HasPrefix(dir, other+"/") {
+					blocked[other] = dir
+				}
+			}
+			continue
+		}
+		os.Remove(dir)
+	}
+	var res []unmountResult
+	for _, v := range mounted {
+		if err, ok := failed[v]; ok {
+			metrics.inc("objectivefs_unmounts_total", "result", "failure")
+			res = append(res, unmountResult{v.volume.Name, err})
+			continue
+		}
+		v.mounted = false
+		caches.unset(v.volume.Name)
+		d.setExpiry(v, nil)
+		if len(v.layers) != 0 {
+			os.Remove(filepath.Join(layerRoot(), v.volume.Name))
+		}
+		metrics.inc("objectivefs_unmounts_total", "result", "success")
+		d.transition("unmount", v)
+		res = append(res, unmountResult{Volume: v.volume.Name})
+	}
+	return res
+}
+
+func (d *ofsDriver) unmountAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	res := d.unmountAll(d.config.unmountPolicy)
+	failed := 0
+	for _, r := range res {
+		if r.Err != "" {
+			failed++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"volumes": res, "failed": failed})
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestMountDeps(t *testing.T) {
+	testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "s3://top,s3://base"})
+	v.volume = &volume.Volume{Name: "vol", Mountpoint: filepath.Join(mountRoot, "vol")}
+	want := map[string][]string{
+		v.volume.Mountpoint: {layerDir(v, 0), layerDir(v, 1)},
+		layerDir(v, 0):      nil,
+		layerDir(v, 1):      nil,
+	}
+	if got := v.mountDeps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mountDeps = %q, want %q", got, want)
+	}
+}
+
+func TestUnmountOrder(t *testing.T) {
+	tests := []struct {
+		deps map[string][]string
+		want []string
+	}{
+		{map[string][]string{"/r/a": nil}, []string{"/r/a"}},
+		{map[string][]string{"/r/a": {"/r/.layers/a/0", "/r/.layers/a/1"}, "/r/.layers/a/0": nil, "/r/.layers/a/1": nil}, []string{"/r/a", "/r/.layers/a/1", "/r/.layers/a/0"}},
+		{map[string][]string{"/r/a": nil, "/r/a/sub": nil, "/r/a/sub/deeper": nil}, []string{"/r/a/sub/deeper", "/r/a/sub", "/r/a"}},
+		{map[string][]string{"/r/ab": nil, "/r/a": nil}, []string{"/r/ab", "/r/a"}},
+	}
+	for _, tt := range tests {
+		got := unmountOrder(tt.deps)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unmountOrder(%q) = %q, want %q", tt.deps, got, tt.want)
+		}
+	}
+}
+
+func TestUnmountAllLayers(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "s3://top,s3://base"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	if res := d.unmountAll(umountNormal); len(res) != 1 || res[0].Err != "" {
+		t.Fatalf("unmountAll = %+v", res)
+	}
+	want := []string{"umount " + v.volume.Mountpoint, "umount " + layerDir(v, 1), "umount " + layerDir(v, 0)}
+	if got := f.umounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unmountAll ran %q, want %q", got, want)
+	}
+	if v.mounted || f.mounts() != 0 {
+		t.Errorf("mounted %v with %d mounts left", v.mounted, f.mounts())
+	}
+}
+
+func TestUnmountGroups(t *testing.T) {
+	tests := []struct {
+		dirs map[string][]string
+		want [][]string
+	}{
+		{map[string][]string{"a": {"/r/a"}, "b": {"/r/b"}}, [][]string{{"a"}, {"b"}}},
+		{map[string][]string{"a": {"/r/a"}, "ab": {"/r/ab"}}, [][]string{{"a"}, {"ab"}}},
+		{map[string][]string{"a": {"/r/a"}, "b": {"/r/a/sub"}, "c": {"/r/c"}}, [][]string{{"a", "b"}, {"c"}}},
+		{map[string][]string{"a": {"/r/a/sub"}, "b": {"/r/a"}}, [][]string{{"a", "b"}}},
+		{map[string][]string{"a": {"/r/a"}, "b": {"/r/a/b"}, "c": {"/r/a/b/c"}}, [][]string{{"a", "b", "c"}}},
+		{map[string][]string{"a": {"/r/a", "/r/.layers/a/0"}, "b": {"/r/.layers/a/0/x"}}, [][]string{{"a", "b"}}},
+		{map[string][]string{}, nil},
+	}
+	for _, tt := range tests {
+		if got := unmountGroups(tt.dirs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unmountGroups(%q) = %q, want %q", tt.dirs, got, tt.want)
+		}
+	}
+}
+
+func TestUnmountAllContinues(t *testing.T) {
+	d := testDriver(t)
+	f := fakeMounts(t, d)
+	for _, name := range []string{"vola", "volb", "volc"} {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"fs": "myfs"}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: "c"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.fail(t, "umount-fail", d.volumes["volb"].volume.Mountpoint)
+	res := d.unmountAll(umountNormal)
+	if len(res) != 3 || res[0].Err != "" || res[1].Err == "" || res[2].Err != "" {
+		t.Fatalf("unmountAll = %+v", res)
+	}
+	if !strings.Contains(res[1].Err, "target is busy") {
+		t.Errorf("unmountAll error %q", res[1].Err)
+	}
+	if len(f.umounts()) != 3 {
+		t.Errorf("unmountAll ran %q, want every volume attempted", f.umounts())
+	}
+	for name, want := range map[string]bool{"vola": false, "volb": true, "volc": false} {
+		if d.volumes[name].mounted != want {
+			t.Errorf("%s mounted %v, want %v", name, d.volumes[name].mounted, want)
+		}
+	}
+	if f.mounts() != 1 {
+		t.Errorf("%d mounts left, want 1", f.mounts())
+	}
+}
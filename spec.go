@@ -0,0 +1,45 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var driverNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// socketPath returns where the plugin socket named socket is created.
+func socketPath(socket string) string {
+	if filepath.IsAbs(socket) {
+		return socket
+	}
+	return filepath.Join("/run/docker/plugins", socket+".sock")
+}
+
+// writeSpec writes the spec file Docker finds the driver called name by,
+// pointing at the plugin socket, and returns its path.
+func writeSpec(dir, name, socket string) (string, error) {
+	path := filepath.Join(dir, name+".spec")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("unix://"+socketPath(socket)+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
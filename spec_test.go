@@ -0,0 +1,61 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSocketPath(t *testing.T) {
+	for socket, want := range map[string]string{
+		"objectivefs":          "/run/docker/plugins/objectivefs.sock",
+		"ofs-2":                "/run/docker/plugins/ofs-2.sock",
+		"/run/ofs/plugin.sock": "/run/ofs/plugin.sock",
+	} {
+		if got := socketPath(socket); got != want {
+			t.Errorf("socketPath(%q) = %q, want %q", socket, got, want)
+		}
+	}
+}
+
+func TestWriteSpec(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "plugins")
+	for _, socket := range []string{"ofs", "/run/ofs.sock"} {
+		path, err := writeSpec(dir, "ofs", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != filepath.Join(dir, "ofs.spec") {
+			t.Errorf("spec written to %q", path)
+		}
+		b, _ := ioutil.ReadFile(path)
+		if want := "unix://" + socketPath(socket) + "\n"; string(b) != want {
+			t.Errorf("spec %q, want %q", b, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ofs.spec.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary spec file left: %v", err)
+	}
+}
+
+func TestDriverNameRe(t *testing.T) {
+	for name, ok := range map[string]bool{"objectivefs": true, "ofs": true, "o": true, "ofs-2.test_x": true, "-ofs": false, "ofs/x": false, "": false, "ofs x": false} {
+		if driverNameRe.MatchString(name) != ok {
+			t.Errorf("driver name %q: valid %v, want %v", name, !ok, ok)
+		}
+	}
+}
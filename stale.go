@@ -0,0 +1,124 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// staleTimeout bounds each stat of a mountpoint, which blocks on a wedged
+// FUSE mount.
+const staleTimeout = 5 * time.Second
+
+type staleMount struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	Err        string `json:"error"`
+}
+
+type orphanMount struct {
+	Dir    string `json:"dir"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+}
+
+var errNotConnected = errors.New("not connected")
+
+// statWithin stats dir, giving up after timeout. A mount whose FUSE
+// process is gone fails with errNotConnected.
+func statWithin(dir string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(dir)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if errors.Is(err, syscall.ENOTCONN) {
+			return errNotConnected
+		}
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no response within %s", timeout)
+	}
+}
+
+// orphanMounts returns the mounts below the mount root that are not one
+// of the known mount directories.
+func orphanMounts(mounts []mountEntry, known map[string]bool) []orphanMount {
+	orphans := []orphanMount{}
+	for _, m := range mounts {
+		if strings.HasPrefix(m.dir, mountRoot+"/") && !known[m.dir] {
+			orphans = append(orphans, orphanMount{m.dir, m.fstype, m.source})
+		}
+	}
+	return orphans
+}
+
+// staleMounts stats the mountpoints of all mounted volumes concurrently
+// and returns those that fail, together with the orphaned mounts.
+func (d *ofsDriver) staleMounts() ([]staleMount, []orphanMount, error) {
+	known := make(map[string]bool)
+	var checks []staleMount
+	for _, v := range d.snapshot() {
+		v.Lock()
+		if v.mounted && !v.removed {
+			checks = append(checks, staleMount{Name: v.volume.Name, Mountpoint: v.volume.Mountpoint})
+			for dir := range v.mountDeps() {
+				known[dir] = true
+			}
+		}
+		v.Unlock()
+	}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(c *staleMount) {
+			defer wg.Done()
+			if err := statWithin(c.Mountpoint, staleTimeout); err != nil {
+				c.Err = err.Error()
+			}
+		}(&checks[i])
+	}
+	wg.Wait()
+	stale := []staleMount{}
+	for _, c := range checks {
+		if c.Err != "" {
+			stale = append(stale, c)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	mounts, err := readMountinfo()
+	if err != nil {
+		return stale, nil, err
+	}
+	return stale, orphanMounts(mounts, known), nil
+}
+
+func (d *ofsDriver) staleMountsHandler(w http.ResponseWriter, r *http.Request) {
+	stale, orphans, err := d.staleMounts()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"Err": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"stale": stale, "orphaned": orphans})
+}
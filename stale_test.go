@@ -0,0 +1,89 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestStatWithin(t *testing.T) {
+	dir := t.TempDir()
+	if err := statWithin(dir, time.Second); err != nil {
+		t.Errorf("statWithin(%q): %v", dir, err)
+	}
+	if err := statWithin(filepath.Join(dir, "gone"), time.Second); !os.IsNotExist(err) {
+		t.Errorf("statWithin of a missing dir: %v", err)
+	}
+}
+
+func TestOrphanMounts(t *testing.T) {
+	testDriver(t)
+	mounts := []mountEntry{
+		{"/", "ext4", "/dev/sda1"},
+		{filepath.Join(mountRoot, "vol"), "fuse.objectivefs", "objectivefs"},
+		{filepath.Join(mountRoot, "gone"), "fuse.objectivefs", "objectivefs"},
+		{filepath.Join(mountRoot, ".layers", "old", "0"), "fuse.objectivefs", "objectivefs"},
+		{mountRoot + "-other/x", "ext4", "/dev/sdb1"},
+	}
+	known := map[string]bool{filepath.Join(mountRoot, "vol"): true}
+	want := []orphanMount{
+		{filepath.Join(mountRoot, "gone"), "fuse.objectivefs", "objectivefs"},
+		{filepath.Join(mountRoot, ".layers", "old", "0"), "fuse.objectivefs", "objectivefs"},
+	}
+	if got := orphanMounts(mounts, known); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphanMounts = %+v, want %+v", got, want)
+	}
+	if got := orphanMounts(nil, known); got == nil || len(got) != 0 {
+		t.Errorf("orphanMounts without mounts = %#v, want empty", got)
+	}
+}
+
+func TestStaleMounts(t *testing.T) {
+	d := testDriver(t)
+	fakeMounts(t, d)
+	for _, name := range []string{"ok", "stale"} {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"fs": "myfs"}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: "c"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(d.volumes["stale"].volume.Mountpoint); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(mountRoot, "orphan")
+	f, err := os.OpenFile(mountinfoPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("1 1 0:1 / " + orphan + " rw - fuse.objectivefs objectivefs rw\n")
+	f.Close()
+	stale, orphans, err := d.staleMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].Name != "stale" || stale[0].Err == "" {
+		t.Errorf("stale %+v, want volume 'stale'", stale)
+	}
+	if len(orphans) != 1 || orphans[0].Dir != orphan {
+		t.Errorf("orphaned %+v, want '%s'", orphans, orphan)
+	}
+}
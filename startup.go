@@ -0,0 +1,107 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// redactURL drops the user info and query of u, which may hold tokens.
+func redactURL(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		if strings.ContainsAny(u, "@?") {
+			return "<redacted>"
+		}
+		return u
+	}
+	if p.User != nil {
+		p.User = url.User("redacted")
+	}
+	if p.RawQuery != "" {
+		p.RawQuery = "redacted"
+	}
+	return p.String()
+}
+
+// redactCommand keeps only the program of a configured command, since its
+// arguments may hold secrets.
+func redactCommand(argv []string) string {
+	if len(argv) == 0 {
+		return ""
+	}
+	if len(argv) == 1 {
+		return argv[0]
+	}
+	return argv[0] + " <" + strconv.Itoa(len(argv)-1) + " args redacted>"
+}
+
+// summary describes the resolved configuration in one line, for the log
+// at startup. Values that may hold secrets are redacted.
+func (c ofsConfig) summary(gid int) string {
+	orNone := func(s string) string {
+		if s == "" {
+			return "none"
+		}
+		return s
+	}
+	limit := func(n int) string {
+		if n <= 0 {
+			return "none"
+		}
+		return strconv.Itoa(n)
+	}
+	webhook := c.webhookURL
+	if webhook != "" {
+		webhook = redactURL(webhook)
+	}
+	otlp := c.otlpEndpoint
+	if otlp != "" {
+		otlp = redactURL(otlp)
+	}
+	kv := []string{
+		"mount_style", c.mountStyle,
+		"mount_binary", c.mountBinary,
+		"mount_wrapper", orNone(redactCommand(c.mountWrapper)),
+		"socket", c.socket,
+		"socket_gid", strconv.Itoa(gid),
+		"driver_name", c.driverName,
+		"mount_root", c.mountRoot,
+		"default_options", "auto",
+		"state_file", orNone(c.stateFile),
+		"volumes_file", orNone(c.volumesFile),
+		"unmount_policy", c.unmountPolicy,
+		"remove_unmount", c.removeUnmount,
+		"unmount_on_exit", strconv.FormatBool(c.unmountOnExit),
+		"orphan_policy", c.orphanPolicy,
+		"metrics_addr", orNone(c.metricsAddr),
+		"webhook_url", orNone(webhook),
+		"otlp_endpoint", orNone(otlp),
+		"credentials_command", orNone(redactCommand(c.credentialsCommand)),
+		"endpoint_mount_limit", limit(c.endpointLimit),
+		"mount_budget", limit(c.mountBudget),
+		"health_interval", c.healthInterval.String(),
+	}
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i != 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%s=%q", kv[i], kv[i+1])
+	}
+	return b.String()
+}
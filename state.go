@@ -0,0 +1,229 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The state file keeps the volume definitions across driver restarts. It
+// holds the create options, which include credentials, so it is only
+// readable by root.
+
+type stateVolume struct {
+	Name      string            `json:"name"`
+	CreatedAt string            `json:"created_at"`
+	Options   map[string]string `json:"options"`
+	Use       []string          `json:"use,omitempty"`
+	// Used tells if the volume was ever mounted. It is missing for volumes
+	// saved before it was recorded, which count as used.
+	Used *bool `json:"used,omitempty"`
+}
+
+// useState is the copy of the use map of every volume that goes into the
+// state file. It has its own lock, taken last, so the state can be saved
+// without locking the volumes.
+type useState struct {
+	sync.Mutex
+	ids   map[string][]string
+	used  map[string]bool
+	dirty bool
+}
+
+// markUsed records that v was mounted, saving the state the first time.
+func (d *ofsDriver) markUsed(v *ofsVolume) {
+	d.use.Lock()
+	first := !d.use.used[v.volume.Name]
+	d.use.used[v.volume.Name] = true
+	d.use.Unlock()
+	if first && d.config.stateFile != "" {
+		d.Lock()
+		d.saveState()
+		d.Unlock()
+	}
+}
+
+// recordUse updates the saved use map of v, with v locked, and saves it
+// according to the persistence policy.
+func (d *ofsDriver) recordUse(v *ofsVolume) {
+	if d.config.stateFile == "" || d.config.usePersist == "never" {
+		return
+	}
+	ids := make([]string, 0, len(v.use))
+	for id := range v.use {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	d.use.Lock()
+	d.use.ids[v.volume.Name] = ids
+	d.use.dirty = true
+	d.use.Unlock()
+	if d.config.usePersist == "immediate" {
+		d.Lock()
+		d.saveState()
+		d.Unlock()
+	}
+}
+
+func (d *ofsDriver) useLoop() {
+	for range time.Tick(d.config.useInterval) {
+		d.use.Lock()
+		dirty := d.use.dirty
+		d.use.Unlock()
+		if dirty {
+			d.Lock()
+			d.saveState()
+			d.Unlock()
+		}
+	}
+}
+
+type stateFile struct {
+	Volumes []stateVolume `json:"volumes"`
+}
+
+// decodeState decodes a state file. If it is damaged, e.g. truncated by a
+// crash during a write, it returns the volumes that could be read before
+// the damage along with the error.
+func decodeState(data []byte) ([]stateVolume, error) {
+	var st stateFile
+	if err := json.Unmarshal(data, &st); err == nil {
+		return st.Volumes, nil
+	}
+	var recovered []stateVolume
+	dec := json.NewDecoder(bytes.NewReader(data))
+	expect := func(want json.Delim) bool {
+		t, err := dec.Token()
+		return err == nil && t == want
+	}
+	if !expect('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return recovered, err
+		}
+		if t != "volumes" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return recovered, err
+			}
+			continue
+		}
+		if !expect('[') {
+			return recovered, fmt.Errorf("volumes is not a list")
+		}
+		for dec.More() {
+			var sv stateVolume
+			if err := dec.Decode(&sv); err != nil {
+				return recovered, err
+			}
+			if sv.Name != "" {
+				recovered = append(recovered, sv)
+			}
+		}
+	}
+	return recovered, fmt.Errorf("malformed state file")
+}
+
+// saveState writes the volume definitions, with the driver lock held.
+func (d *ofsDriver) saveState() {
+	if d.config.stateFile == "" {
+		return
+	}
+	var st stateFile
+	d.use.Lock()
+	for _, v := range d.volumes {
+		used := d.use.used[v.volume.Name]
+		st.Volumes = append(st.Volumes, stateVolume{Name: v.volume.Name, CreatedAt: v.volume.CreatedAt, Options: v.options, Use: d.use.ids[v.volume.Name], Used: &used})
+	}
+	for _, sv := range d.unrestored {
+		if _, ok := d.volumes[sv.Name]; !ok {
+			st.Volumes = append(st.Volumes, sv)
+		}
+	}
+	d.use.dirty = false
+	d.use.Unlock()
+	b, _ := json.MarshalIndent(st, "", "  ")
+	tmp := d.config.stateFile + ".tmp"
+	os.MkdirAll(filepath.Dir(tmp), 0755)
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		log.Printf("Saving state to '%s' failed: %s", d.config.stateFile, err.Error())
+		return
+	}
+	if err := os.Rename(tmp, d.config.stateFile); err != nil {
+		log.Printf("Saving state to '%s' failed: %s", d.config.stateFile, err.Error())
+	}
+}
+
+// loadState restores the volumes of the state file. A damaged file is
+// kept aside as <file>.corrupt-<time> for inspection, and whatever could
+// still be read from it is restored. A volume that cannot be restored is
+// kept in the state file unchanged, since the cause may be temporary.
+func (d *ofsDriver) loadState() {
+	path := d.config.stateFile
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Cannot read state file '%s': %s", path, err.Error())
+	}
+	svs, err := decodeState(data)
+	if err != nil {
+		backup := path + ".corrupt-" + time.Now().Format("20060102T150405")
+		log.Printf("State file '%s' is corrupt (%s), moved to '%s', recovered %d volumes", path, err.Error(), backup, len(svs))
+		if err := os.Rename(path, backup); err != nil {
+			log.Fatalf("Cannot move corrupt state file '%s': %s", path, err.Error())
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, sv := range svs {
+		v, err := newVolume(sv.Name, sv.Options, sv.CreatedAt)
+		if err != nil {
+			log.Printf("Cannot restore ObjectiveFS Volume '%s': %s", sv.Name, err.Error())
+			d.unrestored = append(d.unrestored, sv)
+			continue
+		}
+		if fstype, err := mountType(v.volume.Mountpoint); err == nil && expectedType(fstype, len(v.layers) != 0) {
+			v.mounted, v.fstype, v.mountedAt = true, fstype, time.Now()
+			caches.set(v)
+			for _, id := range sv.Use {
+				v.use[id] = true
+			}
+			if d.config.usePersist != "never" {
+				d.use.ids[sv.Name] = sv.Use
+			}
+		}
+		d.use.used[sv.Name] = sv.Used == nil || *sv.Used || v.mounted
+		d.volumes[sv.Name] = v
+	}
+	log.Printf("Restored %d ObjectiveFS Volumes from '%s'", len(d.volumes), path)
+	if err != nil {
+		d.saveState()
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+const testState = `{"volumes": [
+	{"name": "vola", "created_at": "2020-01-01T00:00:00Z", "options": {"fs": "s3://a"}},
+	{"name": "volb", "created_at": "2020-01-01T00:00:00Z", "options": {"fs": "s3://b"}, "use": ["c"]},
+	{"name": "volc", "created_at": "2020-01-01T00:00:00Z", "options": {"fs": "s3://c"}}
+]}`
+
+func TestDecodeState(t *testing.T) {
+	tests := []struct {
+		data  string
+		names []string
+		ok    bool
+	}{
+		{testState, []string{"vola", "volb", "volc"}, true},
+		{`{"volumes": []}`, nil, true},
+		{testState[:len(testState)-5], []string{"vola", "volb"}, false},
+		{testState[:120], []string{"vola"}, false},
+		{`{"version": 2, "volumes": [{"name": "vola"}, {"options": {}}, {"name": 3}]}`, []string{"vola"}, false},
+		{`{"volumes": {"a": {}}}`, nil, false},
+		{`[{"name": "vola"}]`, nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		svs, err := decodeState([]byte(tt.data))
+		if (err == nil) != tt.ok {
+			t.Errorf("decodeState(%q): %v, want ok %v", tt.data, err, tt.ok)
+		}
+		var names []string
+		for _, sv := range svs {
+			names = append(names, sv.Name)
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("decodeState(%q) = %q, want %q", tt.data, names, tt.names)
+		}
+	}
+}
+
+func TestSaveLoadState(t *testing.T) {
+	d := testDriver(t)
+	d.config.stateFile = filepath.Join(t.TempDir(), "state.json")
+	for _, name := range []string{"vola", "volb"} {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"fs": "s3://" + name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restored := testDriver(t)
+	restored.config.stateFile = d.config.stateFile
+	restored.loadState()
+	if len(restored.volumes) != 2 || restored.volumes["volb"] == nil || restored.volumes["volb"].fs != "s3://volb" {
+		t.Errorf("restored %v", restored.volumes)
+	}
+}
+
+func TestLoadCorruptState(t *testing.T) {
+	d := testDriver(t)
+	dir := t.TempDir()
+	d.config.stateFile = filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(d.config.stateFile, []byte(testState[:len(testState)-5]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d.loadState()
+	if len(d.volumes) != 2 || d.volumes["vola"] == nil || d.volumes["volb"] == nil {
+		t.Errorf("recovered %v, want vola and volb", d.volumes)
+	}
+	backups, _ := filepath.Glob(d.config.stateFile + ".corrupt-*")
+	if len(backups) != 1 {
+		t.Fatalf("backups %q", backups)
+	}
+	if b, _ := ioutil.ReadFile(backups[0]); string(b) != testState[:len(testState)-5] {
+		t.Errorf("backup holds %q", b)
+	}
+}
+
+func TestRecordUse(t *testing.T) {
+	tests := []struct {
+		persist string
+		saved   []string
+		dirty   bool
+	}{
+		{"immediate", []string{"c1", "c2"}, false},
+		{"interval", nil, true},
+		{"never", nil, false},
+	}
+	for _, tt := range tests {
+		d := testDriver(t)
+		d.config.stateFile = filepath.Join(t.TempDir(), "state.json")
+		d.config.usePersist = tt.persist
+		if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+			t.Fatal(err)
+		}
+		v := d.volumes["vol"]
+		v.use["c2"], v.use["c1"] = true, true
+		d.recordUse(v)
+		data, err := ioutil.ReadFile(d.config.stateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svs, err := decodeState(data)
+		if err != nil || len(svs) != 1 {
+			t.Fatalf("%s: state %q: %v", tt.persist, data, err)
+		}
+		if !reflect.DeepEqual(svs[0].Use, tt.saved) {
+			t.Errorf("%s: saved users %q, want %q", tt.persist, svs[0].Use, tt.saved)
+		}
+		if d.use.dirty != tt.dirty {
+			t.Errorf("%s: dirty %v, want %v", tt.persist, d.use.dirty, tt.dirty)
+		}
+	}
+}
+
+func TestKeepUnrestored(t *testing.T) {
+	d := testDriver(t)
+	d.config.stateFile = filepath.Join(t.TempDir(), "state.json")
+	state := `{"volumes": [
+	{"name": "vola", "created_at": "2020-01-01T00:00:00Z", "options": {"fs": "s3://a"}},
+	{"name": "volb", "created_at": "2020-01-01T00:00:00Z", "options": {"fs": "s3://b", "profile": "missing", "OBJECTIVEFS_PASSPHRASE": "pw"}}
+]}`
+	if err := ioutil.WriteFile(d.config.stateFile, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d.loadState()
+	if len(d.volumes) != 1 || d.volumes["vola"] == nil {
+		t.Fatalf("restored %v, want vola", d.volumes)
+	}
+	if b, _ := ioutil.ReadFile(d.config.stateFile); string(b) != state {
+		t.Errorf("state file rewritten after a failed restore:\n%s", b)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "volc", Options: map[string]string{"fs": "s3://c"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(d.config.stateFile)
+	svs, err := decodeState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := make(map[string]stateVolume)
+	for _, sv := range svs {
+		saved[sv.Name] = sv
+	}
+	want := map[string]string{"fs": "s3://b", "profile": "missing", "OBJECTIVEFS_PASSPHRASE": "pw"}
+	if len(saved) != 3 || !reflect.DeepEqual(saved["volb"].Options, want) || saved["volb"].CreatedAt != "2020-01-01T00:00:00Z" {
+		t.Errorf("saved %+v, want volb kept", svs)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "volb", Options: map[string]string{"fs": "s3://new"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(d.config.stateFile)
+	if svs, _ = decodeState(data); len(svs) != 3 {
+		t.Errorf("saved %+v after volb was created again", svs)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+type schemeStats struct {
+	Volumes int `json:"volumes"`
+	Mounted int `json:"mounted"`
+}
+
+type driverStats struct {
+	Volumes  int                     `json:"volumes"`
+	Mounted  int                     `json:"mounted"`
+	Users    int                     `json:"users"`
+	Mounts   map[string]uint64       `json:"mounts"`
+	Unmounts map[string]uint64       `json:"unmounts"`
+	Uptime   string                  `json:"uptime"`
+	Schemes  map[string]*schemeStats `json:"schemes"`
+	Cache    cacheStats              `json:"disk_cache"`
+	Host     *hostReadings           `json:"host,omitempty"`
+}
+
+type cacheStats struct {
+	Bytes uint64 `json:"bytes"`
+	Limit uint64 `json:"limit,omitempty"`
+}
+
+// scheme returns the object store of an ObjectiveFS filesystem name, e.g.
+// gs for gs://bucket. Names without a scheme are on S3.
+func scheme(fs string) string {
+	if i := strings.Index(fs, "://"); i > 0 {
+		return fs[:i]
+	}
+	return "s3"
+}
+
+func counts(name string) map[string]uint64 {
+	return map[string]uint64{
+		"success": metrics.get(name, "result", "success"),
+		"failure": metrics.get(name, "result", "failure"),
+	}
+}
+
+func (d *ofsDriver) collectStats() *driverStats {
+	st := &driverStats{
+		Mounts:   counts("objectivefs_mounts_total"),
+		Unmounts: counts("objectivefs_unmounts_total"),
+		Uptime:   time.Since(startTime).Round(time.Second).String(),
+		Schemes:  make(map[string]*schemeStats),
+		Cache:    cacheStats{caches.total("", nil), d.config.cacheLimit},
+	}
+
+	if h, err := readHost(); err == nil {
+		st.Host = &h
+	}
+	for _, v := range d.snapshot() {
+		v.Lock()
+		mounted, users := v.mounted, len(v.use)
+		v.Unlock()
+		ss := st.Schemes[scheme(v.fs)]
+		if ss == nil {
+			ss = &schemeStats{}
+			st.Schemes[scheme(v.fs)] = ss
+		}
+		st.Volumes++
+		ss.Volumes++
+		if mounted {
+			st.Mounted++
+			ss.Mounted++
+		}
+		st.Users += users
+	}
+	return st
+}
+
+func (d *ofsDriver) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.collectStats())
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"testing"
+)
+
+func TestScheme(t *testing.T) {
+	for fs, want := range map[string]string{"s3://bucket": "s3", "gs://bucket": "gs", "az://c/b": "az", "bucket": "s3", "http://minio:9000/b": "http"} {
+		if got := scheme(fs); got != want {
+			t.Errorf("scheme(%q) = %q, want %q", fs, got, want)
+		}
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	d := testDriver(t)
+	for name, fs := range map[string]string{"a": "s3://a", "b": "gs://b", "c": "gs://c"} {
+		v, err := newVolume(name, map[string]string{"fs": fs}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.volumes[name] = v
+	}
+	d.volumes["b"].mounted = true
+	d.volumes["b"].use["x"] = true
+	d.volumes["b"].use["y"] = true
+	st := d.collectStats()
+	if st.Volumes != 3 || st.Mounted != 1 || st.Users != 2 {
+		t.Errorf("stats %d volumes, %d mounted, %d users, want 3, 1, 2", st.Volumes, st.Mounted, st.Users)
+	}
+	if s3, gs := st.Schemes["s3"], st.Schemes["gs"]; s3 == nil || gs == nil || *s3 != (schemeStats{1, 0}) || *gs != (schemeStats{2, 1}) {
+		t.Errorf("scheme stats %v", st.Schemes)
+	}
+}
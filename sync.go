@@ -0,0 +1,94 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// syncMountpoint flushes the data of the mount at dir. A mount that does
+// not answer a stat within staleTimeout would not answer the sync either,
+// so it is skipped rather than waited on for the whole timeout.
+func syncMountpoint(name, dir string, timeout time.Duration) error {
+	if err := statWithin(dir, staleTimeout); err != nil {
+		return fmt.Errorf("sync of volume '%s' skipped, mount not responding: %s", name, err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "sync", "-f", dir).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("sync of volume '%s' timed out after %s", name, timeout)
+	} else if err != nil {
+		err = fmt.Errorf("sync of volume '%s' failed: %s", name, strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		metrics.inc("objectivefs_sync_total", "result", "failure")
+		return err
+	}
+	metrics.inc("objectivefs_sync_total", "result", "success")
+	return nil
+}
+
+// syncForUnmount syncs the mount of v at dir before it is unmounted, if
+// configured. syncfs makes ObjectiveFS commit what it buffered, so the
+// unmount does not wait on or lose it. An overlay of layers is read-only.
+func (d *ofsDriver) syncForUnmount(v *ofsVolume, dir string) {
+	if !d.config.syncBeforeUnmount || dir != v.volume.Mountpoint || len(v.layers) != 0 {
+		return
+	}
+	if err := syncMountpoint(v.volume.Name, dir, d.config.syncTimeout); err != nil {
+		log.Printf("Warning: sync before unmount of ObjectiveFS Volume '%s' failed: %s", v.volume.Name, err.Error())
+	}
+}
+
+func (d *ofsDriver) syncVolume(name string) error {
+	v, err := d.lookup(name)
+	if err != nil {
+		return err
+	}
+	mounted, dir := v.mounted, v.volume.Mountpoint
+	v.Unlock()
+
+	if !mounted {
+		return fmt.Errorf("volume '%s' not mounted", name)
+	}
+	log.Printf("Sync ObjectiveFS Volume '%s'", name)
+	return syncMountpoint(name, dir, d.config.syncTimeout)
+}
+
+func (d *ofsDriver) syncAll() {
+	for _, v := range d.snapshot() {
+		v.Lock()
+		mounted := v.mounted
+		v.Unlock()
+		if !mounted {
+			continue
+		}
+		if err := syncMountpoint(v.volume.Name, v.volume.Mountpoint, d.config.syncTimeout); err != nil {
+			log.Printf("Periodic sync: %s", err.Error())
+		}
+	}
+}
+
+func (d *ofsDriver) syncLoop() {
+	for range time.Tick(d.config.syncInterval) {
+		d.syncAll()
+	}
+}
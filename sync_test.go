@@ -0,0 +1,110 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestSyncVolume(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	d.volumes["vol"] = v
+	if err := d.syncVolume("other"); err == nil {
+		t.Error("sync of unknown volume succeeded")
+	}
+	if err := d.syncVolume("vol"); err == nil {
+		t.Error("sync of unmounted volume succeeded")
+	}
+	if err := d.makeMountpoint(v.volume.Mountpoint); err != nil {
+		t.Fatal(err)
+	}
+	v.mounted = true
+	before := metrics.get("objectivefs_sync_total", "result", "success")
+	if err := d.syncVolume("vol"); err != nil {
+		t.Errorf("sync of mounted volume: %v", err)
+	}
+	if got := metrics.get("objectivefs_sync_total", "result", "success"); got != before+1 {
+		t.Errorf("sync successes %d, want %d", got, before+1)
+	}
+}
+
+func TestSyncBeforeUnmount(t *testing.T) {
+	tests := []struct {
+		sync, gone bool
+		layers     bool
+		synced     uint64
+	}{
+		{true, false, false, 1},
+		{false, false, false, 0},
+		{true, true, false, 0},
+		{true, false, true, 0},
+	}
+	for _, all := range []bool{false, true} {
+		for _, tt := range tests {
+			d := testDriver(t)
+			fakeMounts(t, d)
+			d.config.syncBeforeUnmount = tt.sync
+			fs := "myfs"
+			if tt.layers {
+				fs = "s3://a,s3://b"
+			}
+			if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": fs}}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+				t.Fatal(err)
+			}
+			v := d.volumes["vol"]
+			if tt.gone {
+				os.Remove(v.volume.Mountpoint)
+			}
+			before := syncs()
+			if all {
+				if res := d.unmountAll(umountNormal); len(res) != 1 || res[0].Err != "" {
+					t.Fatalf("unmountAll = %+v", res)
+				}
+			} else if err := d.umount(v, umountNormal); err != nil {
+				t.Fatal(err)
+			}
+			if n := syncs() - before; n != tt.synced {
+				t.Errorf("unmount all %v, sync %v, mountpoint gone %v, layers %v: %d syncs, want %d", all, tt.sync, tt.gone, tt.layers, n, tt.synced)
+			}
+		}
+	}
+}
+
+// syncs returns the number of syncs run so far.
+func syncs() uint64 {
+	return metrics.get("objectivefs_sync_total", "result", "success") + metrics.get("objectivefs_sync_total", "result", "failure")
+}
+
+func TestSyncNotResponding(t *testing.T) {
+	d := testDriver(t)
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	v.mounted = true
+	d.volumes["vol"] = v
+	before := syncs()
+	if err := d.syncVolume("vol"); err == nil || !strings.Contains(err.Error(), "mount not responding") {
+		t.Errorf("sync of a mount not responding: %v", err)
+	}
+	d.syncAll()
+	if n := syncs() - before; n != 0 {
+		t.Errorf("%d syncs run on a mount not responding", n)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func (s *span) get(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue
+		}
+	}
+	return ""
+}
+
+func TestTracedDriver(t *testing.T) {
+	d := testDriver(t)
+	fakeMounts(t, d)
+	old := traces.spans
+	traces.spans = make(chan *span, 10)
+	defer func() { traces.spans = old }()
+	td := tracedDriver{d}
+	td.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "s3://bucket"}})
+	td.Mount(&volume.MountRequest{Name: "vol", ID: "c"})
+	td.Unmount(&volume.UnmountRequest{Name: "vol", ID: "c"})
+	td.Remove(&volume.RemoveRequest{Name: "vol"})
+	td.Mount(&volume.MountRequest{Name: "vol", ID: "c"})
+	tests := []struct {
+		name, outcome, scheme string
+		code                  int
+	}{
+		{"Create", "ok", "s3", 1},
+		{"Mount", "ok", "s3", 1},
+		{"Unmount", "ok", "s3", 1},
+		{"Remove", "ok", "s3", 1},
+		{"Mount", "error", "", 2},
+	}
+	for _, tt := range tests {
+		s := <-traces.spans
+		if s.Name != tt.name || s.get("volume") != "vol" || s.get("outcome") != tt.outcome || s.get("backend.scheme") != tt.scheme || s.Status.Code != tt.code {
+			t.Errorf("span %s %+v, want %s with outcome %s", s.Name, s.Attributes, tt.name, tt.outcome)
+		}
+		if s.get("duration") == "" || s.Start == "" || s.End < s.Start || len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Errorf("span %s: incomplete %+v", s.Name, s)
+		}
+	}
+	if len(traces.spans) != 0 {
+		t.Errorf("%d spans too many", len(traces.spans))
+	}
+}
+
+func TestTraceExport(t *testing.T) {
+	var got struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []span `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	tr := &tracer{endpoint: srv.URL + "/v1/traces"}
+	s := startSpan("Mount", "vol", "gs://bucket")
+	s.attr("outcome", "ok")
+	tr.export([]*span{s})
+	if path != "/v1/traces" {
+		t.Errorf("exported to %q", path)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("exported %+v", got)
+	}
+	if e := got.ResourceSpans[0].ScopeSpans[0].Spans[0]; e.Name != "Mount" || e.get("backend.scheme") != "gs" {
+		t.Errorf("exported span %+v", e)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var secretKeyRe = regexp.MustCompile(`(?i)(PASSPHRASE|SECRET|PASSWORD|TOKEN|KEY|LICENSE)`)
+
+// redact replaces the values of the secret variables of env in text.
+func redact(env []string, text string) string {
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 && len(kv[1]) >= 4 && secretKeyRe.MatchString(kv[0]) {
+			text = strings.Replace(text, kv[1], "<redacted>", -1)
+		}
+	}
+	return text
+}
+
+func (d *ofsDriver) volumeLog(name string) string {
+	return filepath.Join(d.config.volumeLogDir, name+".log")
+}
+
+// logOutput appends the output of a mount command of v, run with env, to
+// its log file.
+func (d *ofsDriver) logOutput(v *ofsVolume, env []string, what, output string) {
+	if d.config.volumeLogDir == "" {
+		return
+	}
+	os.MkdirAll(d.config.volumeLogDir, 0700)
+	f, err := os.OpenFile(d.volumeLog(v.volume.Name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s %s\n", time.Now().Format(time.RFC3339), what)
+	if output = strings.TrimRight(redact(env, output), "\n"); output != "" {
+		fmt.Fprintln(f, output)
+	}
+}
+
+// tail returns the last lines of text, but no more than max bytes of it.
+func tail(text string, lines, max int) string {
+	if len(text) > max {
+		text = text[len(text)-max:]
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		}
+	}
+	all := strings.SplitAfter(text, "\n")
+	if all[len(all)-1] == "" {
+		all = all[:len(all)-1]
+	}
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "")
+}
+
+func (d *ofsDriver) logs(w http.ResponseWriter, r *http.Request, name string) {
+	if d.config.volumeLogDir == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"Err": "per-volume logs are disabled, set OBJECTIVEFS_VOLUME_LOG_DIR"})
+		return
+	}
+	lines, max := 100, 64<<10
+	var err error
+	if val := r.URL.Query().Get("lines"); val != "" {
+		if lines, err = strconv.Atoi(val); err != nil || lines <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Err": "invalid line count '" + val + "'"})
+			return
+		}
+	}
+	if val := r.URL.Query().Get("bytes"); val != "" {
+		if max, err = strconv.Atoi(val); err != nil || max <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Err": "invalid byte count '" + val + "'"})
+			return
+		}
+	}
+	v, err := d.lookup(name)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"Err": err.Error()})
+		return
+	}
+	b, err := ioutil.ReadFile(d.volumeLog(name))
+	text := redact(v.env, string(b))
+	v.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"Err": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(tail(text, lines, max)))
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	env := []string{
+		"OBJECTIVEFS_PASSPHRASE=hunter2hunter2",
+		"AWS_SECRET_ACCESS_KEY=wJalrXUtnFEMI",
+		"AWS_ACCESS_KEY_ID=AKIAIOSFODNN7",
+		"api_token=tok-123",
+		"OBJECTIVEFS_LICENSE=lic-4567",
+		"PIN=abc",
+		"REGION=us-east-1",
+		"EMPTY=",
+	}
+	text := "passphrase hunter2hunter2, secret wJalrXUtnFEMI, key AKIAIOSFODNN7, token tok-123, license lic-4567, pin abc, region us-east-1"
+	want := "passphrase <redacted>, secret <redacted>, key <redacted>, token <redacted>, license <redacted>, pin abc, region us-east-1"
+	if got := redact(env, text); got != want {
+		t.Errorf("redact = %q, want %q", got, want)
+	}
+}
+
+func TestTail(t *testing.T) {
+	text := "one\ntwo\nthree\nfour\n"
+	tests := []struct {
+		text       string
+		lines, max int
+		want       string
+	}{
+		{text, 10, 100, text},
+		{text, 2, 100, "three\nfour\n"},
+		{text, 10, 12, "three\nfour\n"},
+		{text, 10, 11, "four\n"},
+		{"one\ntwo", 1, 100, "two"},
+		{"", 5, 100, ""},
+	}
+	for _, tt := range tests {
+		if got := tail(tt.text, tt.lines, tt.max); got != tt.want {
+			t.Errorf("tail(%q, %d, %d) = %q, want %q", tt.text, tt.lines, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	d := testDriver(t)
+	d.config.volumeLogDir = t.TempDir()
+	v := testVolume(t, map[string]string{"fs": "myfs"})
+	env := []string{"OBJECTIVEFS_PASSPHRASE=hunter2hunter2"}
+	d.logOutput(v, env, "mount.objectivefs myfs /mnt", "bad passphrase hunter2hunter2\n\n")
+	d.logOutput(v, env, "umount /mnt", "")
+	b, err := ioutil.ReadFile(d.volumeLog("vol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " mount.objectivefs myfs /mnt") || lines[1] != "bad passphrase <redacted>" || !strings.HasSuffix(lines[2], " umount /mnt") {
+		t.Errorf("log %q", b)
+	}
+}
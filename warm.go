@@ -0,0 +1,224 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warmLimits bound a cache warm run.
+type warmLimits struct {
+	timeout time.Duration
+	files   int
+	bytes   uint64
+}
+
+// warmProgress is the state of the last cache warm run of a volume. It
+// is updated by the run without the volume lock. Closing stop ends the
+// run, which closes done once it no longer uses the mount.
+type warmProgress struct {
+	sync.Mutex
+	path     string
+	started  time.Time
+	finished time.Time
+	files    int
+	bytes    uint64
+	errors   int
+	reason   string
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// cancel stops the run, if it is still going, and waits for it to end,
+// so the files it reads do not keep the mount busy.
+func (p *warmProgress) cancel() {
+	if p.stop == nil {
+		return
+	}
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+func (p *warmProgress) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *warmProgress) running() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.finished.IsZero()
+}
+
+func (p *warmProgress) status() map[string]interface{} {
+	p.Lock()
+	defer p.Unlock()
+	s := map[string]interface{}{"path": p.path, "started": p.started.Format(time.RFC3339), "files": p.files, "bytes": p.bytes, "errors": p.errors}
+	if p.finished.IsZero() {
+		s["state"] = "running"
+	} else {
+		s["state"] = "done"
+		s["finished"] = p.finished.Format(time.RFC3339)
+		s["reason"] = p.reason
+	}
+	return s
+}
+
+// warmDir reads the regular files below dir, in walk order, until all
+// were read or one of the limits is reached, and returns why it stopped.
+// Files that cannot be read are counted and skipped.
+func warmDir(dir string, lim warmLimits, p *warmProgress) string {
+	deadline := time.Now().Add(lim.timeout)
+	reason := "complete"
+	errStop := fmt.Errorf("stop")
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			p.Lock()
+			p.errors++
+			p.Unlock()
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		p.Lock()
+		files, bytes := p.files, p.bytes
+		p.Unlock()
+		switch {
+		case p.stopped():
+			reason = "unmounted"
+		case time.Now().After(deadline):
+			reason = "timeout"
+		case files >= lim.files:
+			reason = "file limit"
+		case bytes >= lim.bytes:
+			reason = "byte limit"
+		default:
+			n, err := readFile(path, int64(lim.bytes-bytes), p.stopped)
+			p.Lock()
+			p.files++
+			p.bytes += uint64(n)
+			if err != nil {
+				p.errors++
+			}
+			p.Unlock()
+			return nil
+		}
+		return errStop
+	})
+	return reason
+}
+
+// readFile reads at most max bytes of path, a chunk at a time until
+// stopped tells to stop.
+func readFile(path string, max int64, stopped func() bool) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var total int64
+	for total < max && !stopped() {
+		chunk := max - total
+		if chunk > 1<<20 {
+			chunk = 1 << 20
+		}
+		n, err := io.CopyN(ioutil.Discard, f, chunk)
+		total += n
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func parseWarmLimits(r *http.Request) (warmLimits, error) {
+	lim := warmLimits{timeout: 10 * time.Minute, files: 10000, bytes: 1 << 30}
+	q := r.URL.Query()
+	var err error
+	if val := q.Get("timeout"); val != "" {
+		if lim.timeout, err = time.ParseDuration(val); err != nil || lim.timeout <= 0 {
+			return lim, fmt.Errorf("invalid timeout '%s'", val)
+		}
+	}
+	if val := q.Get("files"); val != "" {
+		if lim.files, err = strconv.Atoi(val); err != nil || lim.files <= 0 {
+			return lim, fmt.Errorf("invalid file count '%s'", val)
+		}
+	}
+	if val := q.Get("bytes"); val != "" {
+		if lim.bytes, err = parseSize(val); err != nil || lim.bytes == 0 {
+			return lim, fmt.Errorf("invalid byte count '%s'", val)
+		}
+	}
+	return lim, nil
+}
+
+// warm starts reading the files below path in the mounted volume name in
+// the background, to fill the ObjectiveFS cache.
+func (d *ofsDriver) warm(name string, r *http.Request) (int, error) {
+	if err := d.ready("warm"); err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	lim, err := parseWarmLimits(r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	path := filepath.Clean("/" + r.URL.Query().Get("path"))
+	v, err := d.lookup(name)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	defer v.Unlock()
+	if !v.mounted {
+		return http.StatusConflict, fmt.Errorf("volume '%s' not mounted", name)
+	}
+	if v.warm != nil && v.warm.running() {
+		return http.StatusConflict, fmt.Errorf("volume '%s' is already being warmed", name)
+	}
+	dir := filepath.Join(v.volume.Mountpoint, path)
+	if !strings.HasPrefix(dir+"/", v.volume.Mountpoint+"/") {
+		return http.StatusBadRequest, fmt.Errorf("invalid path '%s'", path)
+	}
+	p := &warmProgress{path: path, started: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	v.warm = p
+	log.Printf("Warm cache of ObjectiveFS Volume '%s' from '%s'", name, path)
+	go func() {
+		defer close(p.done)
+		reason := warmDir(dir, lim, p)
+		p.Lock()
+		p.finished, p.reason = time.Now(), reason
+		log.Printf("Warmed cache of ObjectiveFS Volume '%s': %d files, %d bytes, %d errors (%s)", name, p.files, p.bytes, p.errors, reason)
+		p.Unlock()
+	}()
+	return http.StatusAccepted, nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestParseWarmLimits(t *testing.T) {
+	tests := []struct {
+		query string
+		want  warmLimits
+		ok    bool
+	}{
+		{"", warmLimits{10 * time.Minute, 10000, 1 << 30}, true},
+		{"timeout=30s&files=5&bytes=2M", warmLimits{30 * time.Second, 5, 2 << 20}, true},
+		{"timeout=0s", warmLimits{}, false},
+		{"timeout=soon", warmLimits{}, false},
+		{"files=0", warmLimits{}, false},
+		{"files=-1", warmLimits{}, false},
+		{"bytes=0", warmLimits{}, false},
+		{"bytes=lots", warmLimits{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseWarmLimits(httptest.NewRequest("POST", "/volumes/vol/warm?"+tt.query, nil))
+		if (err == nil) != tt.ok {
+			t.Errorf("parseWarmLimits(%q): %v, want ok %v", tt.query, err, tt.ok)
+		} else if tt.ok && got != tt.want {
+			t.Errorf("parseWarmLimits(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestWarmDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "sub/c"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		lim    warmLimits
+		reason string
+		files  int
+		bytes  uint64
+	}{
+		{warmLimits{time.Minute, 10, 1000}, "complete", 3, 300},
+		{warmLimits{time.Minute, 2, 1000}, "file limit", 2, 200},
+		{warmLimits{time.Minute, 10, 150}, "byte limit", 2, 150},
+		{warmLimits{-time.Second, 10, 1000}, "timeout", 0, 0},
+	}
+	for _, tt := range tests {
+		p := &warmProgress{}
+		if reason := warmDir(dir, tt.lim, p); reason != tt.reason || p.files != tt.files || p.bytes != tt.bytes || p.errors != 0 {
+			t.Errorf("warmDir(%+v) = %s after %d files, %d bytes, want %s after %d files, %d bytes", tt.lim, reason, p.files, p.bytes, tt.reason, tt.files, tt.bytes)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	d := testDriver(t)
+	fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/volumes/vol/warm?path=/", nil)
+	if code, _ := d.warm("vol", req); code != http.StatusConflict {
+		t.Errorf("warm of a volume not mounted: %d", code)
+	}
+	if code, _ := d.warm("other", req); code != http.StatusNotFound {
+		t.Errorf("warm of an unknown volume: %d", code)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	if err := ioutil.WriteFile(filepath.Join(v.volume.Mountpoint, "f"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, err := d.warm("vol", httptest.NewRequest("POST", "/volumes/vol/warm?path=../..", nil)); code != http.StatusAccepted {
+		t.Fatalf("warm: %d %v", code, err)
+	}
+	for v.warm.running() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := v.warm.status(); s["path"] != "/" || s["files"] != 1 || s["reason"] != "complete" {
+		t.Errorf("warm status %v", s)
+	}
+}
+
+func TestWarmCancel(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &warmProgress{stop: make(chan struct{}), done: make(chan struct{})}
+	close(p.stop)
+	lim := warmLimits{timeout: time.Minute, files: 100, bytes: 1 << 20}
+	if reason := warmDir(dir, lim, p); reason != "unmounted" || p.files != 0 {
+		t.Errorf("stopped warm: %s after %d files", reason, p.files)
+	}
+
+	d := testDriver(t)
+	fakeMounts(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	v := d.volumes["vol"]
+	for i := 0; i < 200; i++ {
+		if err := ioutil.WriteFile(filepath.Join(v.volume.Mountpoint, strconv.Itoa(i)), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code, err := d.warm("vol", httptest.NewRequest("POST", "/volumes/vol/warm?path=/", nil)); code != http.StatusAccepted {
+		t.Fatalf("warm: %d %v", code, err)
+	}
+	v.Lock()
+	err := d.umount(v, umountNormal)
+	v.Unlock()
+	// The fake mount leaves the files in the mountpoint, so it cannot be
+	// removed once unmounted.
+	if err != nil && !strings.Contains(err.Error(), "directory not empty") {
+		t.Fatal(err)
+	}
+	if v.warm.running() {
+		t.Error("warm still running after unmount")
+	}
+	if s := v.warm.status(); s["reason"] != "unmounted" && s["reason"] != "complete" {
+		t.Errorf("warm status %v", s)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// knownOptions are the mount options documented for mount.objectivefs,
+// together with the generic and FUSE mount options it accepts.
+var knownOptions = map[string]bool{
+	"auto": true, "noauto": true, "acl": true, "noacl": true,
+	"bulkdata": true, "nobulkdata": true, "clean": true, "noclean": true,
+	"compact": true, "nocompact": true, "freebw": true, "nofreebw": true,
+	"hpc": true, "nohpc": true, "mboost": true, "nomboost": true,
+	"mt": true, "mtplus": true, "nomt": true, "ocache": true, "noocache": true,
+	"oob": true, "nooob": true, "ratelimit": true, "noratelimit": true,
+	"snapshots": true, "nosnapshots": true, "nonempty": true,
+	"ro": true, "rw": true, "dev": true, "nodev": true, "exec": true, "noexec": true,
+	"suid": true, "nosuid": true, "atime": true, "noatime": true,
+	"diratime": true, "nodiratime": true, "relatime": true, "strictatime": true,
+	"fsavail": true, "retry": true, "attr_timeout": true, "entry_timeout": true,
+}
+
+var unknownOptionRe = regexp.MustCompile(`(?i)(?:unknown|unrecognized|unsupported|invalid|ignoring)(?: mount)? option[s]?[:\s]+['"]?([A-Za-z0-9_.=-]+)`)
+
+func (v *ofsVolume) mountOptions() []string {
+	all := strings.Split(v.opts, ",")
+	for _, g := range v.groups {
+		all = append(all, strings.Split(g, ",")...)
+	}
+	return all
+}
+
+// optionWarnings lists the options the helper complained about in its
+// output, and the options passed to it that are not known to be supported.
+func optionWarnings(opts []string, output string) []string {
+	seen := make(map[string]bool)
+	var warnings []string
+	for _, m := range unknownOptionRe.FindAllStringSubmatch(output, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			warnings = append(warnings, "option '"+m[1]+"' not accepted by the mount helper")
+		}
+	}
+	for _, opt := range opts {
+		name := strings.SplitN(opt, "=", 2)[0]
+		if name == "" || knownOptions[name] || seen[opt] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		warnings = append(warnings, "option '"+name+"' is not a known mount.objectivefs option and may have no effect")
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionWarnings(t *testing.T) {
+	tests := []struct {
+		opts   string
+		output string
+		want   []string
+	}{
+		{"auto,mt,noatime", "", nil},
+		{"auto,attr_timeout=5,retry=3", "", nil},
+		{"auto,turbo", "", []string{"option 'turbo' is not a known mount.objectivefs option and may have no effect"}},
+		{"auto,turbo=9", "", []string{"option 'turbo' is not a known mount.objectivefs option and may have no effect"}},
+		{"auto,mtplus", "mount.objectivefs: unknown option 'mtplus'\n", []string{"option 'mtplus' not accepted by the mount helper"}},
+		{"auto,zzz", "Ignoring option zzz\nunrecognized mount option: zzz\n", []string{"option 'zzz' not accepted by the mount helper"}},
+		{"auto,cache=1G", "invalid options: cache=1G", []string{"option 'cache=1G' not accepted by the mount helper"}},
+		{"auto", "Mounting filesystem with default options", nil},
+	}
+	for _, tt := range tests {
+		if got := optionWarnings(strings.Split(tt.opts, ","), tt.output); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("optionWarnings(%q, %q) = %q, want %q", tt.opts, tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// notify posts an alert about a volume to the configured webhook. It does
+// not wait for the webhook to answer.
+func (d *ofsDriver) notify(event, name string, kv ...string) {
+	if d.config.webhookURL == "" {
+		return
+	}
+	e := map[string]string{"time": time.Now().Format(time.RFC3339Nano), "event": event, "volume": name}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e[kv[i]] = kv[i+1]
+	}
+	b, _ := json.Marshal(e)
+	go func() {
+		res, err := webhookClient.Post(d.config.webhookURL, "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Printf("Webhook for '%s' of '%s' failed: %s", event, name, err.Error())
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			log.Printf("Webhook for '%s' of '%s' failed: %s", event, name, res.Status)
+		}
+	}()
+}
+
+// transition reports a change of the mount state of v, or of its users
+// from none to some and back, to the audit log and the webhook.
+func (d *ofsDriver) transition(event string, v *ofsVolume) {
+	kv := []string{"users", strconv.Itoa(len(v.use)), "mounted", strconv.FormatBool(v.mounted)}
+	audit.record(event, v, kv...)
+	d.notify(event, v.volume.Name, kv...)
+}
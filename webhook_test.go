@@ -0,0 +1,84 @@
+// Copyright (c) 2020, Objective Security Corporation
+
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// webhook returns a webhook server that passes the events it gets on.
+func webhook(t *testing.T, d *ofsDriver) chan map[string]string {
+	events := make(chan map[string]string, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e map[string]string
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	t.Cleanup(srv.Close)
+	d.config.webhookURL = srv.URL
+	return events
+}
+
+func TestTransitions(t *testing.T) {
+	d := testDriver(t)
+	fakeMounts(t, d)
+	events := webhook(t, d)
+	if err := d.Create(&volume.CreateRequest{Name: "vol", Options: map[string]string{"fs": "myfs", "asap": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		if _, err := d.Mount(&volume.MountRequest{Name: "vol", ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"c1", "c2"} {
+		if err := d.Unmount(&volume.UnmountRequest{Name: "vol", ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"first_user users=1 mounted=true", "last_user users=0 mounted=true", "mount users=0 mounted=true", "unmount users=0 mounted=false"}
+	var got []string
+	for len(got) < len(want) {
+		select {
+		case e := <-events:
+			if e["volume"] != "vol" {
+				t.Errorf("event %v", e)
+			}
+			got = append(got, e["event"]+" users="+e["users"]+" mounted="+e["mounted"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events %q, want %q", got, want)
+		}
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}